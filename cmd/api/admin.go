@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"github.com/julienschmidt/httprouter"
+	"io/fs"
+	"movie_api/internal/jsonlog"
+	"movie_api/internal/metrics"
+	"movie_api/internal/validator"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"strings"
+	"time"
+)
+
+// adminRoutes serves operational endpoints on their own listener. Nothing here is authenticated, the listener
+// is meant to be reachable only from inside the network or through its unix socket.
+func (app *application) adminRoutes() http.Handler {
+	router := httprouter.New()
+
+	router.NotFound = http.HandlerFunc(app.notFoundResponse)
+
+	router.MethodNotAllowed = http.HandlerFunc(app.methodNotAllowedResponse)
+
+	router.HandlerFunc(http.MethodGet, "/health/live", app.livenessHandler)
+	router.HandlerFunc(http.MethodGet, "/health/ready", app.readinessHandler)
+
+	router.HandlerFunc(http.MethodGet, "/log-level", app.showLogLevelHandler)
+	router.HandlerFunc(http.MethodPut, "/log-level", app.updateLogLevelHandler)
+
+	router.HandlerFunc(http.MethodPost, "/caches/flush", app.flushCachesHandler)
+
+	router.Handler(http.MethodGet, "/metrics", metrics.DefaultRegistry)
+	router.Handler(http.MethodGet, "/debug/vars", expvar.Handler())
+	router.HandlerFunc(http.MethodGet, "/debug/pprof/*profile", pprofHandler)
+
+	return app.recoverPanic(router)
+}
+
+// adminListener listens on a tcp address, or on a unix socket when addr looks like unix:/path/to/admin.sock
+func adminListener(addr string) (net.Listener, error) {
+	if path, found := strings.CutPrefix(addr, "unix:"); found {
+		// a socket left behind by an unclean exit would make Listen fail
+		err := os.Remove(path)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		return net.Listen("unix", path)
+	}
+
+	return net.Listen("tcp", addr)
+}
+
+// pprofHandler maps the catch all route onto pprof, as httprouter won't let the named profiles sit beside it
+func pprofHandler(w http.ResponseWriter, r *http.Request) {
+	switch httprouter.ParamsFromContext(r.Context()).ByName("profile") {
+	case "/cmdline":
+		pprof.Cmdline(w, r)
+	case "/profile":
+		pprof.Profile(w, r)
+	case "/symbol":
+		pprof.Symbol(w, r)
+	case "/trace":
+		pprof.Trace(w, r)
+	default:
+		pprof.Index(w, r)
+	}
+}
+
+// livenessHandler only proves the process is serving requests, restarting it won't fix a database outage
+func (app *application) livenessHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.writeJson(w, http.StatusOK, envelope{"status": "alive"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readinessHandler fails once shutdown starts, so load balancers drain us, or while the database is unreachable
+func (app *application) readinessHandler(w http.ResponseWriter, r *http.Request) {
+	if app.shuttingDown.Load() {
+		app.errorResponse(w, r, http.StatusServiceUnavailable, "the server is shutting down")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
+	defer cancel()
+
+	if app.db == nil || app.db.PingContext(ctx) != nil {
+		app.errorResponse(w, r, http.StatusServiceUnavailable, "the database is unavailable")
+		return
+	}
+
+	err := app.writeJson(w, http.StatusOK, envelope{"status": "ready"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showLogLevelHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.writeJson(w, http.StatusOK, envelope{"level": app.logger.Level().String()}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateLogLevelHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Level string `json:"level"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	level, err := jsonlog.ParseLevel(input.Level)
+	if err != nil {
+		v := validator.New()
+		v.AddError("level", "must be one of INFO, ERROR, FATAL or OFF")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	app.logger.SetLevel(level)
+
+	err = app.writeJson(w, http.StatusOK, envelope{"level": level.String()}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// flushCachesHandler drops the in process rate limit and login throttle state, e.g. after blocking a
+// legitimate client by mistake. Shared stores are left alone, they're not this instance's to clear.
+func (app *application) flushCachesHandler(w http.ResponseWriter, r *http.Request) {
+	flushed := []string{}
+
+	if limiter, ok := app.limiter.(interface{ Flush() }); ok {
+		limiter.Flush()
+		flushed = append(flushed, "rate_limiter")
+	}
+
+	if app.loginThrottle != nil {
+		app.loginThrottle.flush()
+		flushed = append(flushed, "login_throttle")
+	}
+
+	err := app.writeJson(w, http.StatusOK, envelope{"flushed": flushed}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
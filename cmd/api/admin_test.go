@@ -0,0 +1,75 @@
+package main
+
+import (
+	"movie_api/internal/jsonlog"
+	"movie_api/internal/ratelimit"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAdminRoutes(t *testing.T) {
+	app := &application{
+		logger:        jsonlog.New(os.Stdout, jsonlog.LevelInfo),
+		limiter:       ratelimit.NewMemory(),
+		loginThrottle: newLoginThrottle(3, time.Minute, time.Second, 10*time.Second),
+	}
+
+	tests := []struct {
+		Name           string
+		method         string
+		path           string
+		body           string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{"Liveness", http.MethodGet, "/health/live", "", http.StatusOK, `"alive"`},
+		{"Readiness without a database", http.MethodGet, "/health/ready", "", http.StatusServiceUnavailable, "database"},
+		{"Show log level", http.MethodGet, "/log-level", "", http.StatusOK, `"INFO"`},
+		{"Update log level", http.MethodPut, "/log-level", `{"level": "error"}`, http.StatusOK, `"ERROR"`},
+		{"Unknown log level", http.MethodPut, "/log-level", `{"level": "debug"}`, http.StatusUnprocessableEntity, "must be one of"},
+		{"Flush caches", http.MethodPost, "/caches/flush", "", http.StatusOK, `"rate_limiter"`},
+		{"Expvar", http.MethodGet, "/debug/vars", "", http.StatusOK, "memstats"},
+		{"Pprof index", http.MethodGet, "/debug/pprof/", "", http.StatusOK, "goroutine"},
+	}
+
+	handler := app.adminRoutes()
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body)))
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("Unexpected status code. Expected: %d, Got: %d", tt.expectedStatus, w.Code)
+			}
+
+			if !strings.Contains(w.Body.String(), tt.expectedBody) {
+				t.Errorf("Unexpected body. Expected it to contain: %s, Got: %s", tt.expectedBody, w.Body.String())
+			}
+		})
+	}
+
+	if app.logger.Level() != jsonlog.LevelError {
+		t.Errorf("Unexpected log level. Expected: %s, Got: %s", jsonlog.LevelError, app.logger.Level())
+	}
+}
+
+func TestReadinessHandler_ShuttingDown(t *testing.T) {
+	app := &application{logger: jsonlog.New(os.Stdout, jsonlog.LevelInfo)}
+	app.shuttingDown.Store(true)
+
+	w := httptest.NewRecorder()
+	app.readinessHandler(w, httptest.NewRequest(http.MethodGet, "/health/ready", nil))
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Unexpected status code. Expected: %d, Got: %d", http.StatusServiceUnavailable, w.Code)
+	}
+
+	if !strings.Contains(w.Body.String(), "shutting down") {
+		t.Errorf("Unexpected body: %s", w.Body.String())
+	}
+}
@@ -3,14 +3,45 @@ package main
 import (
 	"context"
 	"movie_api/internal/data"
+	"net"
 	"net/http"
 )
 
 type contextKey string
 
-const userContextKey = contextKey("user")
+const (
+	userContextKey         = contextKey("user")
+	permissionsContextKey  = contextKey("permissions")
+	clientIPContextKey     = contextKey("client_ip")
+	requestIDContextKey    = contextKey("request_id")
+	requestStateContextKey = contextKey("request_state")
+)
+
+// requestState is shared by pointer through the whole chain, so the outer middleware that log and measure a
+// request can see what was learnt about it further in, after the request itself has been replaced
+type requestState struct {
+	// route is the pattern the router matched, unmatched when nothing did
+	route    string
+	userID   int64
+	clientIP string
+}
+
+// contextRequestState returns the request's state, attaching a fresh one when no outer middleware has
+func (app *application) contextRequestState(r *http.Request) (*requestState, *http.Request) {
+	if state, ok := r.Context().Value(requestStateContextKey).(*requestState); ok {
+		return state, r
+	}
+
+	state := &requestState{route: "unmatched"}
+	ctx := context.WithValue(r.Context(), requestStateContextKey, state)
+	return state, r.WithContext(ctx)
+}
 
 func (app *application) contextSetUser(r *http.Request, user *data.User) *http.Request {
+	if state, ok := r.Context().Value(requestStateContextKey).(*requestState); ok {
+		state.userID = user.ID
+	}
+
 	ctx := context.WithValue(r.Context(), userContextKey, user)
 	return r.WithContext(ctx)
 }
@@ -22,3 +53,48 @@ func (app *application) contextGetUser(r *http.Request) *data.User {
 	}
 	return user
 }
+
+// contextSetPermissions stores the permissions authenticate found for the user, so requirePermission and
+// rateLimitUser don't need to look them up again
+func (app *application) contextSetPermissions(r *http.Request, permissions data.Permissions) *http.Request {
+	ctx := context.WithValue(r.Context(), permissionsContextKey, permissions)
+	return r.WithContext(ctx)
+}
+
+func (app *application) contextGetPermissions(r *http.Request) (data.Permissions, bool) {
+	permissions, ok := r.Context().Value(permissionsContextKey).(data.Permissions)
+	return permissions, ok
+}
+
+func (app *application) contextSetClientIP(r *http.Request, ip string) *http.Request {
+	if state, ok := r.Context().Value(requestStateContextKey).(*requestState); ok {
+		state.clientIP = ip
+	}
+
+	ctx := context.WithValue(r.Context(), clientIPContextKey, ip)
+	return r.WithContext(ctx)
+}
+
+// contextGetClientIP falls back to the peer address when resolveClientIP hasn't run, e.g. in handler tests
+func (app *application) contextGetClientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPContextKey).(string); ok {
+		return ip
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func (app *application) contextSetRequestID(r *http.Request, id string) *http.Request {
+	ctx := context.WithValue(r.Context(), requestIDContextKey, id)
+	return r.WithContext(ctx)
+}
+
+// contextGetRequestID returns an empty string outside of logRequests, e.g. in handler tests
+func (app *application) contextGetRequestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDContextKey).(string)
+	return id
+}
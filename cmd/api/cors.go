@@ -0,0 +1,202 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// corsPolicy decides which cross origin requests browsers may make, and what they get to see of the response
+type corsPolicy struct {
+	// origins are exact matches, or patterns like https://*.example.com which match any subdomain
+	origins          []string
+	methods          []string
+	headers          []string
+	exposedHeaders   []string
+	allowCredentials bool
+	// maxAge is how long browsers may cache a preflight response, zero leaves it to the browser
+	maxAge time.Duration
+}
+
+// routeCORS swaps in its own policy for every path under prefix, an empty origin list shuts cross origin access off
+type routeCORS struct {
+	prefix string
+	policy corsPolicy
+}
+
+func (p corsPolicy) validate() error {
+	if !p.allowCredentials {
+		return nil
+	}
+
+	for _, origin := range p.origins {
+		if origin == "*" {
+			return errors.New("cors: the * origin can't be combined with credentials")
+		}
+	}
+
+	return nil
+}
+
+func (p corsPolicy) allowsOrigin(origin string) bool {
+	for _, pattern := range p.origins {
+		if matchOrigin(pattern, origin) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchOrigin compares an origin against a trusted pattern. A * in place of the leftmost label matches one or
+// more subdomains, but never the bare domain, so https://*.example.com doesn't trust https://example.com.
+func matchOrigin(pattern, origin string) bool {
+	if pattern == "*" || pattern == origin {
+		return true
+	}
+
+	scheme, host, found := strings.Cut(pattern, "://*.")
+	if !found {
+		return false
+	}
+
+	rest, found := strings.CutPrefix(origin, scheme+"://")
+	if !found {
+		return false
+	}
+
+	subdomain, found := strings.CutSuffix(rest, "."+host)
+	return found && subdomain != "" && !strings.ContainsAny(subdomain, "/:")
+}
+
+// parseCORSRoutes reads a space separated list of prefix=origin,origin, e.g. "/v1/tokens/=https://app.example.com".
+// The origins can be followed by ;key=value settings for the rest of the policy, anything left out is taken from
+// base: "/v1/tokens/=https://app.example.com;methods=POST;headers=Content-Type;credentials=true;expose=;max-age=1m"
+func parseCORSRoutes(val string, base corsPolicy) ([]routeCORS, error) {
+	var routes []routeCORS
+
+	for _, field := range strings.Fields(val) {
+		prefix, settings, found := strings.Cut(field, "=")
+		if !found || !strings.HasPrefix(prefix, "/") {
+			return nil, fmt.Errorf("malformed cors route %q, expected prefix=origin,origin", field)
+		}
+
+		settingList := strings.Split(settings, ";")
+
+		route := routeCORS{prefix: prefix, policy: base}
+		route.policy.origins = splitList(settingList[0])
+
+		for _, setting := range settingList[1:] {
+			key, value, found := strings.Cut(setting, "=")
+			if !found {
+				return nil, fmt.Errorf("malformed cors route %q, expected key=value after the origins", field)
+			}
+
+			switch key {
+			case "methods":
+				route.policy.methods = splitList(value)
+			case "headers":
+				route.policy.headers = splitList(value)
+			case "expose":
+				route.policy.exposedHeaders = splitList(value)
+			case "credentials":
+				credentials, err := strconv.ParseBool(value)
+				if err != nil {
+					return nil, fmt.Errorf("malformed cors route %q, credentials must be true or false", field)
+				}
+				route.policy.allowCredentials = credentials
+			case "max-age":
+				maxAge, err := time.ParseDuration(value)
+				if err != nil {
+					return nil, fmt.Errorf("malformed cors route %q, max-age must be a duration", field)
+				}
+				route.policy.maxAge = maxAge
+			default:
+				return nil, fmt.Errorf("malformed cors route %q, unknown setting %q", field, key)
+			}
+		}
+
+		if err := route.policy.validate(); err != nil {
+			return nil, fmt.Errorf("cors route %s: %w", prefix, err)
+		}
+
+		routes = append(routes, route)
+	}
+
+	return routes, nil
+}
+
+// splitList splits a comma separated list, dropping empty entries so an empty value is an empty list
+func splitList(val string) []string {
+	var list []string
+
+	for _, item := range strings.Split(val, ",") {
+		if item != "" {
+			list = append(list, item)
+		}
+	}
+
+	return list
+}
+
+// corsPolicy picks the policy for a request, the longest matching route prefix wins
+func (app *application) corsPolicy(r *http.Request) corsPolicy {
+	policy := app.config.cors.policy
+
+	longest := 0
+
+	for _, route := range app.config.cors.routes {
+		if strings.HasPrefix(r.URL.Path, route.prefix) && len(route.prefix) > longest {
+			policy = route.policy
+			longest = len(route.prefix)
+		}
+	}
+
+	return policy
+}
+
+func (app *application) enableCORS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// we can't guarantee the Access control header will be in every request, so add it as vary
+		w.Header().Add("Vary", "Origin")
+		w.Header().Add("Vary", "Access-Control-Request-Method")
+		w.Header().Add("Vary", "Access-Control-Request-Headers")
+
+		origin := r.Header.Get("Origin")
+
+		if origin != "" {
+			policy := app.corsPolicy(r)
+
+			if policy.allowsOrigin(origin) {
+				// always echo the origin rather than *, browsers refuse * on credentialed requests
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+
+				if policy.allowCredentials {
+					w.Header().Set("Access-Control-Allow-Credentials", "true")
+				}
+
+				// if the request has http.Method options and that the header has an access control request method
+				// this will let us know if it is preflight or not.
+				if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+					w.Header().Set("Access-Control-Allow-Methods", strings.Join(policy.methods, ", "))
+					w.Header().Set("Access-Control-Allow-Headers", strings.Join(policy.headers, ", "))
+
+					if policy.maxAge > 0 {
+						w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(policy.maxAge.Seconds())))
+					}
+
+					w.WriteHeader(http.StatusOK)
+					return
+				}
+
+				if len(policy.exposedHeaders) > 0 {
+					w.Header().Set("Access-Control-Expose-Headers", strings.Join(policy.exposedHeaders, ", "))
+				}
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMatchOrigin(t *testing.T) {
+	tests := []struct {
+		Name     string
+		pattern  string
+		origin   string
+		expected bool
+	}{
+		{"Exact match", "http://localhost:9000", "http://localhost:9000", true},
+		{"Different port", "http://localhost:9000", "http://localhost:9001", false},
+		{"Any origin", "*", "https://evil.example.net", true},
+		{"Subdomain", "https://*.example.com", "https://app.example.com", true},
+		{"Nested subdomain", "https://*.example.com", "https://a.b.example.com", true},
+		{"Bare domain", "https://*.example.com", "https://example.com", false},
+		{"Wrong scheme", "https://*.example.com", "http://app.example.com", false},
+		{"Lookalike domain", "https://*.example.com", "https://app.badexample.com", false},
+		{"Suffix smuggling", "https://*.example.com", "https://example.com.evil.net", false},
+		{"Port smuggling", "https://*.example.com", "https://evil.net:1.example.com", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			if got := matchOrigin(tt.pattern, tt.origin); got != tt.expected {
+				t.Errorf("Unexpected match for %s against %s. Expected: %t, Got: %t", tt.origin, tt.pattern, tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestParseCORSRoutes(t *testing.T) {
+	base := corsPolicy{
+		origins: []string{"http://localhost:9000"},
+		methods: []string{"GET", "POST"},
+		headers: []string{"Authorization"},
+		maxAge:  10 * time.Minute,
+	}
+
+	routes, err := parseCORSRoutes("/v1/tokens/=https://app.example.com,https://*.example.com;methods=POST;credentials=true /debug/=", base)
+	if err != nil {
+		t.Fatalf("Failed to parse routes: %v", err)
+	}
+
+	if len(routes) != 2 || len(routes[0].policy.origins) != 2 || len(routes[1].policy.origins) != 0 {
+		t.Fatalf("Unexpected routes. Got: %+v", routes)
+	}
+
+	// settings given replace the base, the rest are inherited
+	tokens := routes[0].policy
+	if len(tokens.methods) != 1 || !tokens.allowCredentials || len(tokens.headers) != 1 || tokens.maxAge != 10*time.Minute {
+		t.Errorf("Unexpected route policy. Got: %+v", tokens)
+	}
+
+	for _, val := range []string{
+		"v1=https://app.example.com",
+		"/v1/=https://app.example.com;methods",
+		"/v1/=https://app.example.com;colour=blue",
+		"/v1/=https://app.example.com;max-age=soon",
+		"/v1/=*;credentials=true",
+	} {
+		if _, err := parseCORSRoutes(val, base); err == nil {
+			t.Errorf("Expected an error parsing %q", val)
+		}
+	}
+}
+
+func TestCORSPolicy_Validate(t *testing.T) {
+	policy := corsPolicy{origins: []string{"*"}, allowCredentials: true}
+
+	if err := policy.validate(); err == nil {
+		t.Error("Expected an error when credentials are allowed for any origin")
+	}
+}
+
+// the scenarios below are the ones the old cmd/examples/cors pages exercised by hand from a browser on :9000
+func TestEnableCORS(t *testing.T) {
+	app := &application{}
+	app.config.cors.policy = corsPolicy{
+		origins:          []string{"http://localhost:9000", "https://*.example.com"},
+		methods:          []string{"GET", "POST", "PUT", "PATCH", "DELETE"},
+		headers:          []string{"Authorization", "Content-Type"},
+		exposedHeaders:   []string{"Location", "ETag"},
+		allowCredentials: true,
+		maxAge:           10 * time.Minute,
+	}
+	app.config.cors.routes = []routeCORS{{prefix: "/v1/tokens/", policy: corsPolicy{
+		origins: []string{"https://login.example.com"},
+		methods: []string{"POST"},
+		headers: []string{"Content-Type"},
+	}}}
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+
+	tests := []struct {
+		Name           string
+		method         string
+		path           string
+		origin         string
+		preflight      bool
+		expectedStatus int
+		expectedOrigin string
+		expectedHeader map[string]string
+	}{
+		{
+			Name:           "Simple request",
+			method:         http.MethodGet,
+			path:           "/v1/healthcheck",
+			origin:         "http://localhost:9000",
+			expectedStatus: http.StatusTeapot,
+			expectedOrigin: "http://localhost:9000",
+			expectedHeader: map[string]string{
+				"Access-Control-Allow-Credentials": "true",
+				"Access-Control-Expose-Headers":    "Location, ETag",
+			},
+		},
+		{
+			Name:           "Simple request from an untrusted origin",
+			method:         http.MethodGet,
+			path:           "/v1/healthcheck",
+			origin:         "http://localhost:9001",
+			expectedStatus: http.StatusTeapot,
+		},
+		{
+			Name:           "Simple request from a wildcard subdomain",
+			method:         http.MethodGet,
+			path:           "/v1/movies",
+			origin:         "https://app.example.com",
+			expectedStatus: http.StatusTeapot,
+			expectedOrigin: "https://app.example.com",
+		},
+		{
+			Name:           "Preflight request",
+			method:         http.MethodOptions,
+			path:           "/v1/movies",
+			origin:         "http://localhost:9000",
+			preflight:      true,
+			expectedStatus: http.StatusOK,
+			expectedOrigin: "http://localhost:9000",
+			expectedHeader: map[string]string{
+				"Access-Control-Allow-Methods": "GET, POST, PUT, PATCH, DELETE",
+				"Access-Control-Allow-Headers": "Authorization, Content-Type",
+				"Access-Control-Max-Age":       "600",
+			},
+		},
+		{
+			Name:           "Preflight request to a route override",
+			method:         http.MethodOptions,
+			path:           "/v1/tokens/authentication",
+			origin:         "https://login.example.com",
+			preflight:      true,
+			expectedStatus: http.StatusOK,
+			expectedOrigin: "https://login.example.com",
+			// the override replaces the whole policy, not just the origins
+			expectedHeader: map[string]string{
+				"Access-Control-Allow-Methods":     "POST",
+				"Access-Control-Allow-Headers":     "Content-Type",
+				"Access-Control-Allow-Credentials": "",
+				"Access-Control-Max-Age":           "",
+			},
+		},
+		{
+			Name:           "Preflight request from an origin the route override drops",
+			method:         http.MethodOptions,
+			path:           "/v1/tokens/authentication",
+			origin:         "http://localhost:9000",
+			preflight:      true,
+			expectedStatus: http.StatusTeapot,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.path, nil)
+			r.Header.Set("Origin", tt.origin)
+			if tt.preflight {
+				r.Header.Set("Access-Control-Request-Method", http.MethodPost)
+			}
+
+			w := httptest.NewRecorder()
+			app.enableCORS(next).ServeHTTP(w, r)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("Unexpected status code. Expected: %d, Got: %d", tt.expectedStatus, w.Code)
+			}
+
+			if got := w.Header().Get("Access-Control-Allow-Origin"); got != tt.expectedOrigin {
+				t.Errorf("Unexpected allowed origin. Expected: %s, Got: %s", tt.expectedOrigin, got)
+			}
+
+			if vary := strings.Join(w.Header().Values("Vary"), ", "); !strings.Contains(vary, "Access-Control-Request-Headers") {
+				t.Errorf("Expected Vary to include Access-Control-Request-Headers, Got: %s", vary)
+			}
+
+			for name, expected := range tt.expectedHeader {
+				if got := w.Header().Get(name); got != expected {
+					t.Errorf("Unexpected %s header. Expected: %s, Got: %s", name, expected, got)
+				}
+			}
+		})
+	}
+}
@@ -2,7 +2,10 @@ package main
 
 import (
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 func (app *application) logError(r *http.Request, err error) {
@@ -10,11 +13,18 @@ func (app *application) logError(r *http.Request, err error) {
 	app.logger.PrintError(err, map[string]string{
 		"request_method": r.Method,
 		"request_url":    r.URL.String(),
+		"client_ip":      app.contextGetClientIP(r),
+		"request_id":     app.contextGetRequestID(r),
 	})
 }
 
 func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, status int, message any) {
 	env := envelope{"error": message}
+	// lets a client quote the failing request when reporting it, and support find it in the logs
+	if id := app.contextGetRequestID(r); id != "" {
+		env["request_id"] = id
+	}
+
 	err := app.writeJson(w, status, env, nil)
 	if err != nil {
 		app.logError(r, err)
@@ -82,3 +92,14 @@ func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Requ
 	message := "your account doesn't have the permissions for this resource"
 	app.errorResponse(w, r, http.StatusForbidden, message)
 }
+
+func (app *application) tooManyLoginAttemptsResponse(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	message := "too many failed login attempts, please wait before trying again"
+	app.errorResponse(w, r, http.StatusTooManyRequests, message)
+}
+
+func (app *application) accountLockedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "this account has been temporarily locked due to too many failed login attempts, check your email to unlock it"
+	app.errorResponse(w, r, http.StatusLocked, message)
+}
@@ -130,9 +130,11 @@ func (app *application) background(fn func()) {
 	// Launch a background goroutine.
 
 	app.wg.Add(1)
+	app.backgroundTasks.Add(1)
 
 	go func() {
 		defer app.wg.Done()
+		defer app.backgroundTasks.Add(-1)
 		// Recover any panic.
 		defer func() {
 			if err := recover(); err != nil {
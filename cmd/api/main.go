@@ -1,6 +1,7 @@
 package main
 
 import (
+	"database/sql"
 	"expvar"
 	"flag"
 	"fmt"
@@ -8,11 +9,17 @@ import (
 	"github.com/spf13/viper"
 	"movie_api/internal/data"
 	"movie_api/internal/jsonlog"
+	"movie_api/internal/jwt"
 	"movie_api/internal/mailer"
+	"movie_api/internal/metrics"
+	"movie_api/internal/ratelimit"
+	"movie_api/internal/realip"
+	"movie_api/internal/trace"
 	"os"
 	"runtime"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -21,17 +28,19 @@ const (
 )
 
 type config struct {
-	port int
-	env  string
-	db   struct {
-		maxOpenConns int
-		maxIdleConns int
-		maxIdleTime  string
-	}
+	port    int
+	env     string
+	db      dbConfig
 	limiter struct {
 		rps     float64
 		burst   int
 		enabled bool
+		routes  []routeLimit
+		// store is "memory" for limits per instance or "postgres" to share them between instances
+		store string
+		// quotas are multiplied for authenticated users, and again for those who can write movies
+		userMultiplier       float64
+		privilegedMultiplier float64
 	}
 	smtp struct {
 		host     string
@@ -41,7 +50,68 @@ type config struct {
 		sender   string
 	}
 	cors struct {
-		trustedOrigins []string
+		policy corsPolicy
+		routes []routeCORS
+	}
+	security securityHeaders
+	tracing  struct {
+		// exporter is one of none, stdout, file or otlp
+		exporter     string
+		file         string
+		otlpEndpoint string
+		sampleRatio  float64
+	}
+	// admin serves the operational endpoints, on a tcp address or unix:/path socket. Empty turns it off.
+	admin struct {
+		addr string
+	}
+	// proxies are our own load balancers, whose forwarding headers we believe
+	proxies struct {
+		trusted []string
+	}
+	login struct {
+		enabled         bool
+		maxFailures     int
+		lockoutDuration time.Duration
+		ipMaxFailures   int
+		delayBase       time.Duration
+		delayMax        time.Duration
+	}
+	password struct {
+		// hasher is either "bcrypt" or "argon2id"
+		hasher     string
+		bcryptCost int
+		argon2id   struct {
+			memory      uint32
+			iterations  uint32
+			parallelism uint8
+		}
+	}
+	registration struct {
+		// inviteOnly requires an invite token from an admin to register
+		inviteOnly bool
+	}
+	magicLink struct {
+		ttl time.Duration
+		// url is the page the emailed link opens, it's given the token as a query parameter
+		url    string
+		limit  int
+		window time.Duration
+	}
+	deletion struct {
+		// gracePeriod is how long a deleted account is kept before it is purged
+		gracePeriod time.Duration
+	}
+	auth struct {
+		// tokenMode is either "opaque" for database backed tokens or "jwt" for signed tokens that are checked
+		// against the user's token generation instead of the tokens table
+		tokenMode string
+		jwt       struct {
+			activeKey string
+			keys      map[string][]byte
+			ttl       time.Duration
+			issuer    string
+		}
 	}
 }
 
@@ -50,7 +120,19 @@ type application struct {
 	logger *jsonlog.Logger
 	models data.Models
 	mailer mailer.Mailer
-	wg     sync.WaitGroup
+	signer *jwt.Signer
+	// loginThrottle tracks failed logins per client ip
+	loginThrottle *loginThrottle
+	limiter       ratelimit.Store
+	ipResolver    *realip.Resolver
+	tracer        *trace.Tracer
+	// db is kept for the readiness check, everything else goes through models
+	db *sql.DB
+	// shuttingDown flips once a signal arrives, failing readiness so load balancers stop sending traffic
+	shuttingDown atomic.Bool
+	wg           sync.WaitGroup
+	// backgroundTasks mirrors wg's counter, which a WaitGroup won't tell us
+	backgroundTasks atomic.Int64
 }
 
 func main() {
@@ -68,10 +150,16 @@ func main() {
 	flag.StringVar(&cfg.env, "env", "development", "Environment (dev|stag|prod)")
 	flag.IntVar(&cfg.db.maxOpenConns, "db-max-open-conns", 25, "PostgreSQL max open connections")
 	flag.IntVar(&cfg.db.maxIdleConns, "db-max-idle-conns", 25, "PostgreSQL max idle connections")
-	flag.StringVar(&cfg.db.maxIdleTime, "db-max-idle-time", "15m", "PostgreSQL max connection idle time")
+	flag.DurationVar(&cfg.db.maxIdleTime, "db-max-idle-time", 15*time.Minute, "PostgreSQL max connection idle time")
+	flag.BoolVar(&cfg.db.migrateOnStart, "migrate-on-start", false, "Apply pending database migrations before serving")
 
 	flag.Func("cors-trusted-origins", "Trusted CORS origins (space separated)", func(val string) error {
-		cfg.cors.trustedOrigins = strings.Fields(val)
+		cfg.cors.policy.origins = strings.Fields(val)
+		return nil
+	})
+
+	flag.Func("trusted-proxies", "Trusted proxy CIDRs (space separated)", func(val string) error {
+		cfg.proxies.trusted = strings.Fields(val)
 		return nil
 	})
 
@@ -81,14 +169,228 @@ func main() {
 	cfg.limiter.burst = viper.GetInt("LIMITER_BURST")
 	cfg.limiter.enabled = viper.GetBool("LIMITER_ENABLED")
 
+	viper.SetDefault("LIMITER_STORE", "memory")
+	viper.SetDefault("LIMITER_ROUTES", "/v1/tokens/=0.2:5")
+	viper.SetDefault("LIMITER_USER_MULTIPLIER", 2)
+	viper.SetDefault("LIMITER_PRIVILEGED_MULTIPLIER", 5)
+
+	cfg.limiter.store = viper.GetString("LIMITER_STORE")
+	cfg.limiter.userMultiplier = viper.GetFloat64("LIMITER_USER_MULTIPLIER")
+	cfg.limiter.privilegedMultiplier = viper.GetFloat64("LIMITER_PRIVILEGED_MULTIPLIER")
+
 	cfg.smtp.host = viper.GetString("EMAIL_HOST")
 	cfg.smtp.port = viper.GetInt("EMAIL_PORT")
 	cfg.smtp.username = viper.GetString("EMAIL_USERNAME")
 	cfg.smtp.password = viper.GetString("EMAIL_PASSWORD")
 	cfg.smtp.sender = "support@moviebuffs.com"
 
+	viper.SetDefault("LOGIN_PROTECTION_ENABLED", true)
+	viper.SetDefault("LOGIN_MAX_FAILURES", 5)
+	viper.SetDefault("LOGIN_LOCKOUT_DURATION", "15m")
+	viper.SetDefault("LOGIN_IP_MAX_FAILURES", 20)
+	viper.SetDefault("LOGIN_DELAY_BASE", "1s")
+	viper.SetDefault("LOGIN_DELAY_MAX", "30s")
+
+	cfg.login.enabled = viper.GetBool("LOGIN_PROTECTION_ENABLED")
+	cfg.login.maxFailures = viper.GetInt("LOGIN_MAX_FAILURES")
+	cfg.login.lockoutDuration = viper.GetDuration("LOGIN_LOCKOUT_DURATION")
+	cfg.login.ipMaxFailures = viper.GetInt("LOGIN_IP_MAX_FAILURES")
+	cfg.login.delayBase = viper.GetDuration("LOGIN_DELAY_BASE")
+	cfg.login.delayMax = viper.GetDuration("LOGIN_DELAY_MAX")
+
+	viper.SetDefault("PASSWORD_HASHER", "bcrypt")
+	viper.SetDefault("BCRYPT_COST", 12)
+	viper.SetDefault("ARGON2_MEMORY", 64*1024)
+	viper.SetDefault("ARGON2_ITERATIONS", 3)
+	viper.SetDefault("ARGON2_PARALLELISM", 2)
+
+	cfg.password.hasher = viper.GetString("PASSWORD_HASHER")
+	cfg.password.bcryptCost = viper.GetInt("BCRYPT_COST")
+	cfg.password.argon2id.memory = viper.GetUint32("ARGON2_MEMORY")
+	cfg.password.argon2id.iterations = viper.GetUint32("ARGON2_ITERATIONS")
+	cfg.password.argon2id.parallelism = uint8(viper.GetUint("ARGON2_PARALLELISM"))
+
+	viper.SetDefault("REGISTRATION_MODE", "open")
+
+	cfg.registration.inviteOnly = viper.GetString("REGISTRATION_MODE") == "invite"
+
+	viper.SetDefault("MAGIC_LINK_TTL", "15m")
+	viper.SetDefault("MAGIC_LINK_URL", "http://localhost:3000/login/magic")
+	viper.SetDefault("MAGIC_LINK_LIMIT", 3)
+	viper.SetDefault("MAGIC_LINK_WINDOW", "15m")
+
+	cfg.magicLink.ttl = viper.GetDuration("MAGIC_LINK_TTL")
+	cfg.magicLink.url = viper.GetString("MAGIC_LINK_URL")
+	cfg.magicLink.limit = viper.GetInt("MAGIC_LINK_LIMIT")
+	cfg.magicLink.window = viper.GetDuration("MAGIC_LINK_WINDOW")
+
+	viper.SetDefault("DELETION_GRACE_PERIOD", "720h")
+
+	cfg.deletion.gracePeriod = viper.GetDuration("DELETION_GRACE_PERIOD")
+
+	viper.SetDefault("CORS_ALLOWED_METHODS", "GET POST PUT PATCH DELETE")
+	viper.SetDefault("CORS_ALLOWED_HEADERS", "Authorization Content-Type")
+	viper.SetDefault("CORS_EXPOSED_HEADERS", "Location ETag RateLimit-Limit RateLimit-Remaining RateLimit-Reset Retry-After")
+	viper.SetDefault("CORS_ALLOW_CREDENTIALS", false)
+	viper.SetDefault("CORS_MAX_AGE", "10m")
+
+	cfg.cors.policy.methods = strings.Fields(viper.GetString("CORS_ALLOWED_METHODS"))
+	cfg.cors.policy.headers = strings.Fields(viper.GetString("CORS_ALLOWED_HEADERS"))
+	cfg.cors.policy.exposedHeaders = strings.Fields(viper.GetString("CORS_EXPOSED_HEADERS"))
+	cfg.cors.policy.allowCredentials = viper.GetBool("CORS_ALLOW_CREDENTIALS")
+	cfg.cors.policy.maxAge = viper.GetDuration("CORS_MAX_AGE")
+
+	viper.SetDefault("SECURITY_HEADERS_ENABLED", true)
+	viper.SetDefault("SECURITY_CSP", "default-src 'none'; frame-ancestors 'none'")
+	viper.SetDefault("SECURITY_REFERRER_POLICY", "no-referrer")
+	viper.SetDefault("SECURITY_HSTS_MAX_AGE", "8760h")
+	viper.SetDefault("SECURITY_HSTS_INCLUDE_SUBDOMAINS", false)
+
+	cfg.security.enabled = viper.GetBool("SECURITY_HEADERS_ENABLED")
+	cfg.security.contentSecurityPolicy = viper.GetString("SECURITY_CSP")
+	cfg.security.referrerPolicy = viper.GetString("SECURITY_REFERRER_POLICY")
+	cfg.security.hstsMaxAge = viper.GetDuration("SECURITY_HSTS_MAX_AGE")
+	cfg.security.hstsIncludeSubdomains = viper.GetBool("SECURITY_HSTS_INCLUDE_SUBDOMAINS")
+
+	cfg.admin.addr = viper.GetString("ADMIN_ADDR")
+
+	// the TEST_DATABASE_* keys predate the DB_* ones and are still read, so existing local.env files keep working
+	legacyDBKeys := map[string]string{
+		"DB_HOST":     "TEST_DATABASE_HOST",
+		"DB_PORT":     "TEST_DATABASE_PORT",
+		"DB_USER":     "TEST_DATABASE_USER",
+		"DB_PASSWORD": "TEST_DATABASE_PASSWORD",
+		"DB_NAME":     "TEST_DATABASE",
+	}
+
+	usedLegacyDBKeys := false
+	for key, legacy := range legacyDBKeys {
+		if !viper.IsSet(key) && viper.IsSet(legacy) {
+			viper.Set(key, viper.Get(legacy))
+			usedLegacyDBKeys = true
+		}
+	}
+
+	viper.SetDefault("DB_HOST", "localhost")
+	viper.SetDefault("DB_PORT", 5432)
+	viper.SetDefault("DB_SSLMODE", "prefer")
+	viper.SetDefault("DB_APPLICATION_NAME", "movie_api")
+	// a backstop for queries that escape DB_QUERY_TIMEOUTS, it must stay above the longest of those
+	viper.SetDefault("DB_STATEMENT_TIMEOUT", "1m")
+	viper.SetDefault("DB_CONN_MAX_LIFETIME", "1h")
+	viper.SetDefault("DB_CONNECT_TIMEOUT", "30s")
+
+	cfg.db.dsn = viper.GetString("DB_DSN")
+	cfg.db.host = viper.GetString("DB_HOST")
+	cfg.db.port = viper.GetInt("DB_PORT")
+	cfg.db.user = viper.GetString("DB_USER")
+	cfg.db.password = viper.GetString("DB_PASSWORD")
+	cfg.db.name = viper.GetString("DB_NAME")
+	cfg.db.sslMode = viper.GetString("DB_SSLMODE")
+	cfg.db.applicationName = viper.GetString("DB_APPLICATION_NAME")
+	cfg.db.statementTimeout = viper.GetDuration("DB_STATEMENT_TIMEOUT")
+	cfg.db.connMaxLifetime = viper.GetDuration("DB_CONN_MAX_LIFETIME")
+	cfg.db.connectTimeout = viper.GetDuration("DB_CONNECT_TIMEOUT")
+
+	viper.SetDefault("DB_QUERY_TIMEOUT", "3s")
+	// purging walks every due account, so it gets longer than the rest
+	viper.SetDefault("DB_QUERY_TIMEOUTS", "DeletionModel.PurgeDue=30s")
+
+	viper.SetDefault("TRACING_EXPORTER", "none")
+	viper.SetDefault("TRACING_FILE", "traces.jsonl")
+	viper.SetDefault("TRACING_OTLP_ENDPOINT", "http://localhost:4318/v1/traces")
+	viper.SetDefault("TRACING_SAMPLE_RATIO", 1.0)
+
+	cfg.tracing.exporter = viper.GetString("TRACING_EXPORTER")
+	cfg.tracing.file = viper.GetString("TRACING_FILE")
+	cfg.tracing.otlpEndpoint = viper.GetString("TRACING_OTLP_ENDPOINT")
+	cfg.tracing.sampleRatio = viper.GetFloat64("TRACING_SAMPLE_RATIO")
+
+	viper.SetDefault("AUTH_TOKEN_MODE", "opaque")
+	viper.SetDefault("JWT_TTL", "15m")
+	viper.SetDefault("JWT_ISSUER", "movie_api")
+
+	cfg.auth.tokenMode = viper.GetString("AUTH_TOKEN_MODE")
+	cfg.auth.jwt.activeKey = viper.GetString("JWT_ACTIVE_KEY")
+	cfg.auth.jwt.ttl = viper.GetDuration("JWT_TTL")
+	cfg.auth.jwt.issuer = viper.GetString("JWT_ISSUER")
+
 	logger := jsonlog.New(os.Stdout, jsonlog.LevelInfo)
 
+	if usedLegacyDBKeys {
+		logger.PrintInfo("TEST_DATABASE_* settings are deprecated, use DB_HOST, DB_PORT, DB_USER, DB_PASSWORD and DB_NAME", nil)
+	}
+
+	routeLimits, err := parseRouteLimits(viper.GetString("LIMITER_ROUTES"))
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+	cfg.limiter.routes = routeLimits
+
+	queryTimeouts, err := data.ParseTimeoutOverrides(viper.GetString("DB_QUERY_TIMEOUTS"))
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+	data.Timeouts = data.QueryTimeouts{
+		Default:   viper.GetDuration("DB_QUERY_TIMEOUT"),
+		Overrides: queryTimeouts,
+	}
+
+	if err := cfg.cors.policy.validate(); err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
+	corsRoutes, err := parseCORSRoutes(viper.GetString("CORS_ROUTES"), cfg.cors.policy)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+	cfg.cors.routes = corsRoutes
+
+	if cfg.limiter.store != "memory" && cfg.limiter.store != "postgres" {
+		logger.PrintFatal(fmt.Errorf("unknown limiter store %q", cfg.limiter.store), nil)
+	}
+
+	switch cfg.password.hasher {
+	case "bcrypt":
+		data.PreferredHasher = data.BcryptHasher{Cost: cfg.password.bcryptCost}
+	case "argon2id":
+		data.PreferredHasher = data.Argon2idHasher{
+			Memory:      cfg.password.argon2id.memory,
+			Iterations:  cfg.password.argon2id.iterations,
+			Parallelism: cfg.password.argon2id.parallelism,
+			SaltLength:  16,
+			KeyLength:   32,
+		}
+	default:
+		logger.PrintFatal(fmt.Errorf("unknown password hasher %q", cfg.password.hasher), nil)
+	}
+
+	ipResolver, err := realip.New(cfg.proxies.trusted)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
+	var signer *jwt.Signer
+
+	if cfg.auth.tokenMode == "jwt" {
+		keys, err := jwt.ParseKeys(viper.GetString("JWT_KEYS"))
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+		cfg.auth.jwt.keys = keys
+
+		signer, err = jwt.New(cfg.auth.jwt.activeKey, cfg.auth.jwt.issuer, cfg.auth.jwt.keys)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+	}
+
+	tracer, err := newTracer(cfg, logger)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+	trace.SetTracer(tracer)
+
 	expvar.NewString("version").Set(version)
 
 	expvar.Publish("goroutines", expvar.Func(func() any {
@@ -99,13 +401,42 @@ func main() {
 		return time.Now().Unix()
 	}))
 
+	metrics.DefaultRegistry.NewGaugeFunc("goroutines", "Goroutines that currently exist.", func() float64 {
+		return float64(runtime.NumGoroutine())
+	})
+
 	app := &application{
-		config: cfg,
-		logger: logger,
-		mailer: mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		config:     cfg,
+		logger:     logger,
+		mailer:     mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		signer:     signer,
+		ipResolver: ipResolver,
+		tracer:     tracer,
+		loginThrottle: newLoginThrottle(cfg.login.ipMaxFailures, cfg.login.lockoutDuration,
+			cfg.login.delayBase, cfg.login.delayMax),
+	}
+
+	metrics.DefaultRegistry.NewGaugeFunc("background_tasks_in_flight", "Background tasks, such as emails, still running.", func() float64 {
+		return float64(app.backgroundTasks.Load())
+	})
+
+	// subcommands like `api migrate up` do their job and exit rather than serving
+	if args := flag.Args(); len(args) > 0 {
+		switch args[0] {
+		case "migrate":
+			err = app.migrateCommand(args[1:])
+		case "seed":
+			err = app.seedCommand(args[1:])
+		default:
+			err = fmt.Errorf("unknown command %q", args[0])
+		}
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+		return
 	}
 
-	err := app.serve()
+	err = app.serve()
 	if err != nil {
 		logger.PrintFatal(err, nil)
 	}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"database/sql"
+	"github.com/julienschmidt/httprouter"
+	"movie_api/internal/metrics"
+	"net/http"
+)
+
+// patternRouter records which route pattern served a request, so metrics can be labelled by
+// /v1/movies/:id rather than giving every movie id its own series
+type patternRouter struct {
+	*httprouter.Router
+}
+
+func newPatternRouter() patternRouter {
+	return patternRouter{httprouter.New()}
+}
+
+func (pr patternRouter) Handler(method, path string, handler http.Handler) {
+	pr.Router.Handler(method, path, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if state, ok := r.Context().Value(requestStateContextKey).(*requestState); ok {
+			state.route = path
+		}
+		handler.ServeHTTP(w, r)
+	}))
+}
+
+func (pr patternRouter) HandlerFunc(method, path string, handler http.HandlerFunc) {
+	pr.Handler(method, path, handler)
+}
+
+// registerDBMetrics exposes the connection pool, read from sql.DB.Stats at scrape time
+func registerDBMetrics(db *sql.DB) {
+	gauges := map[string]struct {
+		help string
+		fn   func(s sql.DBStats) float64
+	}{
+		"db_max_open_connections": {"Maximum number of open connections to the database.", func(s sql.DBStats) float64 { return float64(s.MaxOpenConnections) }},
+		"db_open_connections":     {"Established connections, in use and idle.", func(s sql.DBStats) float64 { return float64(s.OpenConnections) }},
+		"db_in_use_connections":   {"Connections currently in use.", func(s sql.DBStats) float64 { return float64(s.InUse) }},
+		"db_idle_connections":     {"Idle connections.", func(s sql.DBStats) float64 { return float64(s.Idle) }},
+	}
+
+	for name, g := range gauges {
+		fn := g.fn
+		metrics.DefaultRegistry.NewGaugeFunc(name, g.help, func() float64 { return fn(db.Stats()) })
+	}
+
+	metrics.DefaultRegistry.NewCounterFunc("db_wait_count_total", "Connections waited for.", func() float64 {
+		return float64(db.Stats().WaitCount)
+	})
+	metrics.DefaultRegistry.NewCounterFunc("db_wait_duration_seconds_total", "Time spent waiting for a connection.", func() float64 {
+		return db.Stats().WaitDuration.Seconds()
+	})
+	metrics.DefaultRegistry.NewCounterFunc("db_max_idle_closed_total", "Connections closed due to the idle limit.", func() float64 {
+		return float64(db.Stats().MaxIdleClosed)
+	})
+	metrics.DefaultRegistry.NewCounterFunc("db_max_idle_time_closed_total", "Connections closed due to the idle time limit.", func() float64 {
+		return float64(db.Stats().MaxIdleTimeClosed)
+	})
+}
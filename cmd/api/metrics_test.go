@@ -0,0 +1,33 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPatternRouter(t *testing.T) {
+	router := newPatternRouter()
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id", func(w http.ResponseWriter, r *http.Request) {})
+
+	tests := []struct {
+		Name     string
+		path     string
+		expected string
+	}{
+		{"Matched route", "/v1/movies/42", "/v1/movies/:id"},
+		{"Unmatched route", "/v1/nothing-here", "unmatched"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			state, r := app.contextRequestState(httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			router.ServeHTTP(httptest.NewRecorder(), r)
+
+			if state.route != tt.expected {
+				t.Errorf("Unexpected route. Expected: %s, Got: %s", tt.expected, state.route)
+			}
+		})
+	}
+}
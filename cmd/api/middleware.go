@@ -1,17 +1,19 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"expvar"
 	"fmt"
-	"golang.org/x/time/rate"
 	"movie_api/internal/data"
+	"movie_api/internal/jwt"
+	"movie_api/internal/metrics"
+	"movie_api/internal/trace"
 	"movie_api/internal/validator"
-	"net"
 	"net/http"
 	"strconv"
 	"strings"
-	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -19,6 +21,7 @@ type metricsResponseWriter struct {
 	wrapped       http.ResponseWriter
 	statusCode    int
 	headerWritten bool
+	bytesWritten  int
 }
 
 func newMetricsResponseWriter(w http.ResponseWriter) *metricsResponseWriter {
@@ -42,7 +45,9 @@ func (mw *metricsResponseWriter) WriteHeader(statusCode int) {
 
 func (mw *metricsResponseWriter) Write(b []byte) (int, error) {
 	mw.headerWritten = true
-	return mw.wrapped.Write(b)
+	n, err := mw.wrapped.Write(b)
+	mw.bytesWritten += n
+	return n, err
 }
 func (mw *metricsResponseWriter) Unwrap() http.ResponseWriter {
 	return mw.wrapped
@@ -63,64 +68,85 @@ func (app *application) recoverPanic(next http.Handler) http.Handler {
 	})
 }
 
-func (app *application) rateLimit(next http.Handler) http.Handler {
-	type client struct {
-		limiter  *rate.Limiter
-		lastSeen time.Time
-	}
-
-	var (
-		mu      sync.Mutex
-		clients = make(map[string]*client)
-	)
+// resolveClientIP works out who the client is once, taking trusted proxies into account, so everything
+// further down the chain agrees on the address
+func (app *application) resolveClientIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.ipResolver == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
 
-	go func() {
-		for {
-			time.Sleep(time.Minute)
+		ip, err := app.ipResolver.ClientIP(r)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
 
-			mu.Lock()
+		next.ServeHTTP(w, app.contextSetClientIP(r, ip))
+	})
+}
 
-			for ip, client := range clients {
-				if time.Since(client.lastSeen) > 3*time.Minute {
-					delete(clients, ip)
-				}
-			}
-			mu.Unlock()
+// rateLimitIP keys anonymous requests on their ip. Requests carrying a token are left to later stages, since a
+// bigger quota can only be given once authenticate has accepted the token: a bad one is charged to this same
+// anonymous bucket by authenticate, a good one to a bigger per ip bucket by rateLimitUser.
+func (app *application) rateLimitIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "" || app.allowAnonymousIP(w, r) {
+			next.ServeHTTP(w, r)
 		}
-	}()
+	})
+}
+
+// allowAnonymousIP spends a token from the client ip's anonymous bucket, it always allows when limiting is off
+func (app *application) allowAnonymousIP(w http.ResponseWriter, r *http.Request) bool {
+	if !app.config.limiter.enabled {
+		return true
+	}
 
+	return app.allowRequest(w, r, "ip:"+app.contextGetClientIP(r), app.rateLimitPolicy(r))
+}
+
+// rateLimitUser only sees requests authenticate accepted. Their ip gets a bucket sized for the largest user tier,
+// so many users behind one address aren't held to the anonymous quota, then the user is keyed on their id. They
+// get a bigger quota than anonymous clients, and a bigger one again if they can write movies.
+func (app *application) rateLimitUser(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if app.config.limiter.enabled {
-			ip, _, err := net.SplitHostPort(r.RemoteAddr)
-			if err != nil {
-				app.serverErrorResponse(w, r, err)
-				return
-			}
-			mu.Lock()
-			// check to see if the ip exists, if not create it
-			if _, found := clients[ip]; !found {
-				clients[ip] = &client{
-					limiter: rate.NewLimiter(rate.Limit(app.config.limiter.rps), app.config.limiter.burst),
-				}
-			}
+		user := app.contextGetUser(r)
 
-			clients[ip].lastSeen = time.Now()
+		if !app.config.limiter.enabled || user.IsAnonymous() {
+			next.ServeHTTP(w, r)
+			return
+		}
 
-			if !clients[ip].limiter.Allow() {
-				mu.Unlock()
-				app.rateLimitExceededResponse(w, r)
-				return
-			}
+		policy := app.rateLimitPolicy(r)
 
-			mu.Unlock()
+		if !app.allowRequest(w, r, "token-ip:"+app.contextGetClientIP(r), policy.Scale(app.config.limiter.privilegedMultiplier)) {
+			return
 		}
 
-		next.ServeHTTP(w, r)
+		// authenticate puts the permissions in the context for both kinds of token
+		if permissions, ok := app.contextGetPermissions(r); ok && permissions.Include("movies:write") {
+			policy = policy.Scale(app.config.limiter.privilegedMultiplier)
+		} else {
+			policy = policy.Scale(app.config.limiter.userMultiplier)
+		}
+
+		if app.allowRequest(w, r, "user:"+strconv.FormatInt(user.ID, 10), policy) {
+			next.ServeHTTP(w, r)
+		}
 	})
 }
 
+// errInvalidAuthenticationToken is any reason a token isn't accepted, the client is told no more than that
+var errInvalidAuthenticationToken = errors.New("invalid authentication token")
+
 func (app *application) authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := trace.Start(r.Context(), "authenticate")
+		defer span.Finish()
+		next := finishBefore(span, next)
+
 		w.Header().Add("Vary", "Authorization")
 
 		authorizationHeader := r.Header.Get("Authorization")
@@ -131,26 +157,14 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			return
 		}
 
-		headerParts := strings.Split(authorizationHeader, " ")
-		if len(headerParts) != 2 || headerParts[0] != "Bearer" {
-			app.invalidAuthenticationTokenResponse(w, r)
-			return
-		}
-
-		token := headerParts[1]
-
-		v := validator.New()
-
-		if data.ValidateTokenPlainText(v, token); !v.Valid() {
-			app.invalidAuthenticationTokenResponse(w, r)
-			return
-		}
-
-		user, err := app.models.Users.GetForToken(data.ScopeAuthentication, token)
+		user, permissions, err := app.authenticateHeader(ctx, authorizationHeader)
 		if err != nil {
 			switch {
-			case errors.Is(err, data.ErrRecordNotFound):
-				app.invalidAuthenticationTokenResponse(w, r)
+			case errors.Is(err, errInvalidAuthenticationToken):
+				// a bad token earns nothing over an anonymous request, so guessing them is limited just the same
+				if app.allowAnonymousIP(w, r) {
+					app.invalidAuthenticationTokenResponse(w, r)
+				}
 			default:
 				app.serverErrorResponse(w, r, err)
 			}
@@ -158,11 +172,75 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		}
 
 		r = app.contextSetUser(r, user)
+		r = app.contextSetPermissions(r, permissions)
 
 		next.ServeHTTP(w, r)
 	})
 }
 
+// authenticateHeader finds the user behind a bearer token and their permissions, any token that can't be used
+// is errInvalidAuthenticationToken
+func (app *application) authenticateHeader(ctx context.Context, authorizationHeader string) (*data.User, data.Permissions, error) {
+	headerParts := strings.Split(authorizationHeader, " ")
+	if len(headerParts) != 2 || headerParts[0] != "Bearer" {
+		return nil, nil, errInvalidAuthenticationToken
+	}
+
+	token := headerParts[1]
+
+	if app.config.auth.tokenMode == "jwt" && jwt.LooksSigned(token) {
+		claims, err := app.signer.Verify(token)
+		if err != nil {
+			return nil, nil, errInvalidAuthenticationToken
+		}
+
+		// the signature is checked without the database, but the user is still loaded so a password change can
+		// revoke the token by moving the token generation on, and a deleted or pending deletion account stops
+		// working at once. The permissions are the ones it was signed with.
+		user, err := app.models.Users.Get(ctx, claims.Subject)
+		if err != nil {
+			if errors.Is(err, data.ErrRecordNotFound) {
+				return nil, nil, errInvalidAuthenticationToken
+			}
+			return nil, nil, err
+		}
+
+		if user.TokenGeneration != claims.Generation || user.DeletionPending {
+			return nil, nil, errInvalidAuthenticationToken
+		}
+
+		return user, claims.Permissions, nil
+	}
+
+	v := validator.New()
+
+	if data.ValidateTokenPlainText(v, token); !v.Valid() {
+		return nil, nil, errInvalidAuthenticationToken
+	}
+
+	user, err := app.models.Users.GetForToken(ctx, data.ScopeAuthentication, token)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return nil, nil, errInvalidAuthenticationToken
+		}
+		return nil, nil, err
+	}
+
+	// scheduling a deletion deletes the account's tokens, this catches one racing with that
+	if user.DeletionPending {
+		return nil, nil, errInvalidAuthenticationToken
+	}
+
+	// loaded here rather than in requirePermission so rateLimitUser can tier session tokens too, the query isn't
+	// an extra one for the routes that check a permission
+	permissions, err := app.models.Permissions.GetAllForUser(ctx, user.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return user, permissions, nil
+}
+
 func (app *application) requireAuthenticatedUser(next http.HandlerFunc) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		user := app.contextGetUser(r)
@@ -191,13 +269,21 @@ func (app *application) requireActivatedUser(next http.HandlerFunc) http.Handler
 
 func (app *application) requirePermission(code string, next http.HandlerFunc) http.HandlerFunc {
 	fn := func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := trace.Start(r.Context(), "requirePermission")
+		span.SetAttribute("permission", code)
+		defer span.Finish()
+		next := finishBefore(span, next)
 
 		user := app.contextGetUser(r)
 
-		permissions, err := app.models.Permissions.GetAllForUser(user.ID)
-		if err != nil {
-			app.serverErrorResponse(w, r, err)
-			return
+		permissions, ok := app.contextGetPermissions(r)
+		if !ok {
+			var err error
+			permissions, err = app.models.Permissions.GetAllForUser(ctx, user.ID)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
 		}
 
 		if !permissions.Include(code) {
@@ -211,45 +297,33 @@ func (app *application) requirePermission(code string, next http.HandlerFunc) ht
 	return app.requireActivatedUser(fn)
 }
 
-func (app *application) enableCORS(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// we can't guarantee the Access control header will be in every request, so add it as vary
-		w.Header().Add("Vary", "Origin")
-		w.Header().Add("Vary", "Access-Control-Request-Method")
-
-		origin := r.Header.Get("Origin")
-
-		if origin != "" {
-			for i := range app.config.cors.trustedOrigins {
-				if origin == app.config.cors.trustedOrigins[i] {
-					w.Header().Set("Access-Control-Allow-Origin", origin)
-					// if the request has http.Method options and that the header has an access control request method
-					// this will let us know if it is preflight or not.
-					if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
-						w.Header().Set("Access-Control-Allow-Methods", "OPTIONS, PUT, PATCH, DELETE")
-						w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
-						w.WriteHeader(http.StatusOK)
-						return
-					}
-					break
-				}
-			}
-		}
-		next.ServeHTTP(w, r)
-	})
-}
-
 func (app *application) metrics(next http.Handler) http.Handler {
 	var (
 		totalRequestsReceived           = expvar.NewInt("total_requests_received")
 		totalResponsesSent              = expvar.NewInt("total_responses_sent")
 		totalProcessingTimeMicroseconds = expvar.NewInt("total_processing_time_μs")
 		totalResponsesSentByStatus      = expvar.NewMap("total_responses_sent_by_status")
+
+		requestsInFlight atomic.Int64
+		requests         = metrics.DefaultRegistry.NewCounterVec("http_requests_total",
+			"HTTP requests served, by route pattern, method and status.", "route", "method", "status")
+		requestDuration = metrics.DefaultRegistry.NewHistogramVec("http_request_duration_seconds",
+			"HTTP request latency, by route pattern, method and status.", metrics.DefaultBuckets, "route", "method", "status")
 	)
 
+	metrics.DefaultRegistry.NewGaugeFunc("http_requests_in_flight", "HTTP requests currently being served.", func() float64 {
+		return float64(requestsInFlight.Load())
+	})
+
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
 
+		requestsInFlight.Add(1)
+		defer requestsInFlight.Add(-1)
+
+		// the route is filled in by patternRouter, anything answered before the router, or not found, stays unmatched
+		state, r := app.contextRequestState(r)
+
 		totalRequestsReceived.Add(1)
 		mw := newMetricsResponseWriter(w)
 		next.ServeHTTP(mw, r)
@@ -258,7 +332,11 @@ func (app *application) metrics(next http.Handler) http.Handler {
 
 		totalResponsesSentByStatus.Add(strconv.Itoa(mw.statusCode), 1)
 
-		duration := time.Since(start).Microseconds()
-		totalProcessingTimeMicroseconds.Add(duration)
+		duration := time.Since(start)
+		totalProcessingTimeMicroseconds.Add(duration.Microseconds())
+
+		status := strconv.Itoa(mw.statusCode)
+		requests.Inc(state.route, r.Method, status)
+		requestDuration.Observe(duration.Seconds(), state.route, r.Method, status)
 	})
 }
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"movie_api/internal/migrate"
+	"movie_api/migrations"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+var errMigrateUsage = errors.New("usage: api migrate up|down|status|goto VERSION")
+
+// migrateCommand runs the migrate subcommand against the configured database instead of starting the server
+func (app *application) migrateCommand(args []string) error {
+	var run func(migrator *migrate.Migrator, ctx context.Context) ([]migrate.Step, error)
+
+	// the arguments are checked before connecting, a typo shouldn't have to wait out the connect timeout
+	switch {
+	case len(args) == 1 && args[0] == "up":
+		run = (*migrate.Migrator).Up
+	case len(args) == 1 && args[0] == "down":
+		run = (*migrate.Migrator).Down
+	case len(args) == 2 && args[0] == "goto":
+		version, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return errMigrateUsage
+		}
+		run = func(migrator *migrate.Migrator, ctx context.Context) ([]migrate.Step, error) {
+			return migrator.Goto(ctx, version)
+		}
+	case len(args) == 1 && args[0] == "status":
+	default:
+		return errMigrateUsage
+	}
+
+	db, err := app.openDB(app.config.db)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	migrator, err := migrate.New(db, migrations.Files)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	if run == nil {
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		return printMigrationStatus(os.Stdout, statuses)
+	}
+
+	steps, err := run(migrator, ctx)
+
+	// steps that finished before a failure were committed, so they're worth reporting either way
+	app.logMigrationSteps(steps)
+
+	if err == nil && len(steps) == 0 {
+		app.logger.PrintInfo("schema is up to date", nil)
+	}
+
+	return err
+}
+
+// migrateUp applies pending migrations before serving, for deployments run with -migrate-on-start
+func (app *application) migrateUp(db *sql.DB) error {
+	migrator, err := migrate.New(db, migrations.Files)
+	if err != nil {
+		return err
+	}
+
+	steps, err := migrator.Up(context.Background())
+	app.logMigrationSteps(steps)
+
+	return err
+}
+
+func (app *application) logMigrationSteps(steps []migrate.Step) {
+	for _, step := range steps {
+		direction := "down"
+		if step.Up {
+			direction = "up"
+		}
+
+		app.logger.PrintInfo("applied migration", map[string]string{
+			"version":   strconv.FormatInt(step.Version, 10),
+			"name":      step.Name,
+			"direction": direction,
+		})
+	}
+}
+
+func printMigrationStatus(w io.Writer, statuses []migrate.Status) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(tw, "VERSION\tNAME\tSTATUS\tAPPLIED AT")
+
+	for _, status := range statuses {
+		state, appliedAt := "pending", ""
+
+		if status.Applied {
+			state, appliedAt = "applied", status.AppliedAt.Format(time.RFC3339)
+		}
+		if status.NeedsAdoption {
+			// golang-migrate didn't record when, Up fills that in as it adopts the table
+			state, appliedAt = "needs adoption", ""
+		}
+		if status.Modified {
+			state = "modified"
+		}
+
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", status.Version, status.Name, state, appliedAt)
+	}
+
+	return tw.Flush()
+}
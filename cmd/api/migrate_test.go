@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bytes"
+	"movie_api/internal/migrate"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPrintMigrationStatus(t *testing.T) {
+	appliedAt := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+
+	statuses := []migrate.Status{
+		{Migration: migrate.Migration{Version: 1, Name: "create_movies_table"}, Applied: true, AppliedAt: appliedAt},
+		{Migration: migrate.Migration{Version: 2, Name: "create_users_table"}, Applied: true, AppliedAt: appliedAt, Modified: true},
+		{Migration: migrate.Migration{Version: 3, Name: "add_permissions"}, Applied: true, NeedsAdoption: true},
+		{Migration: migrate.Migration{Version: 4, Name: "add_tokens"}},
+	}
+
+	var buf bytes.Buffer
+	if err := printMigrationStatus(&buf, statuses); err != nil {
+		t.Fatalf("Failed to print status: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 5 {
+		t.Fatalf("Unexpected line count. Expected: 5, Got: %d\n%s", len(lines), buf.String())
+	}
+
+	expected := []string{"applied", "modified", "needs adoption", "pending"}
+	for i, state := range expected {
+		if !strings.Contains(lines[i+1], state) {
+			t.Errorf("Unexpected status on line %d. Expected: %s, Got: %s", i+1, state, lines[i+1])
+		}
+	}
+
+	if !strings.Contains(lines[1], "2026-10-19T12:00:00Z") {
+		t.Errorf("Expected the applied time to be shown, Got: %s", lines[1])
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"github.com/julienschmidt/httprouter"
+	"movie_api/internal/data"
+	"movie_api/internal/jsonlog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestMovieHandlers(t *testing.T) {
+	app := &application{
+		logger: jsonlog.New(os.Stdout, jsonlog.LevelError),
+		models: data.NewMemoryModels(),
+	}
+
+	movie := &data.Movie{Title: "Moana", Year: 2016, Runtime: 107, Genres: []string{"animation", "adventure"}}
+	if err := app.models.Movies.Insert(context.Background(), movie); err != nil {
+		t.Fatalf("Failed to insert movie: %v", err)
+	}
+
+	tests := []struct {
+		Name           string
+		handler        http.HandlerFunc
+		method         string
+		url            string
+		id             string
+		body           string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{"Show movie", app.showMovieHandler, http.MethodGet, "/v1/movies/1", "1", "", http.StatusOK, `"title": "Moana"`},
+		{"Show missing movie", app.showMovieHandler, http.MethodGet, "/v1/movies/2", "2", "", http.StatusNotFound, "could not be found"},
+		{"List by title", app.listMoviesHandler, http.MethodGet, "/v1/movies?title=moana", "", "", http.StatusOK, `"total_records": 1`},
+		{"List by genre", app.listMoviesHandler, http.MethodGet, "/v1/movies?genres=comedy", "", "", http.StatusOK, `"movies": []`},
+		{"Update movie", app.updateMovieHandler, http.MethodPatch, "/v1/movies/1", "1", `{"title": "Vaiana"}`, http.StatusOK, `"version": 2`},
+		{"Invalid update", app.updateMovieHandler, http.MethodPatch, "/v1/movies/1", "1", `{"year": 1700}`, http.StatusUnprocessableEntity, "greater than 1888"},
+		{"Delete movie", app.deleteMovieHandler, http.MethodDelete, "/v1/movies/1", "1", "", http.StatusOK, "successfully deleted"},
+		{"Delete deleted movie", app.deleteMovieHandler, http.MethodDelete, "/v1/movies/1", "1", "", http.StatusNotFound, "could not be found"},
+	}
+
+	// the cases share one store and run in order, each builds on the state left by the last
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.url, strings.NewReader(tt.body))
+			r = r.WithContext(context.WithValue(r.Context(), httprouter.ParamsKey, httprouter.Params{{Key: "id", Value: tt.id}}))
+
+			w := httptest.NewRecorder()
+			tt.handler(w, r)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("Unexpected status code. Expected: %d, Got: %d", tt.expectedStatus, w.Code)
+			}
+
+			if !strings.Contains(w.Body.String(), tt.expectedBody) {
+				t.Errorf("Unexpected body. Expected it to contain: %s, Got: %s", tt.expectedBody, w.Body.String())
+			}
+		})
+	}
+}
@@ -4,69 +4,150 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
-	_ "github.com/jackc/pgx/v4/stdlib"
-	"github.com/spf13/viper"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/stdlib"
+	"strconv"
+	"strings"
 	"time"
 )
 
-type PostgressConfig struct {
-	Host     string
-	Port     string
-	User     string
-	Password string
-	Database string
-	SSLMODE  string
+type dbConfig struct {
+	// dsn is used as is when set, either a postgres:// url or key=value pairs, instead of the fields below
+	dsn      string
+	host     string
+	port     int
+	user     string
+	password string
+	name     string
+	sslMode  string
+
+	// applicationName shows up in pg_stat_activity, statementTimeout caps every query server side. Either is
+	// only applied when the dsn doesn't already set it.
+	applicationName  string
+	statementTimeout time.Duration
+
+	maxOpenConns    int
+	maxIdleConns    int
+	maxIdleTime     time.Duration
+	connMaxLifetime time.Duration
+
+	// connectTimeout is how long startup keeps retrying while postgres isn't accepting connections yet
+	connectTimeout time.Duration
+	// migrateOnStart applies pending migrations before the server starts listening
+	migrateOnStart bool
 }
 
-// Open will open a sql connection with the provided Postgres. Callers will need to ensure it's closed
-func (app *application) Open(cfg PostgressConfig) (*sql.DB, error) {
-	db, err := sql.Open(
-		"pgx",
-		cfg.String(),
-	)
-	if err != nil {
-		return nil, fmt.Errorf("error Opening DB: %w", err)
+// connString builds a key=value connection string from the discrete fields, unless a dsn was given
+func (cfg dbConfig) connString() string {
+	if cfg.dsn != "" {
+		return cfg.dsn
+	}
+
+	pairs := []string{}
+	add := func(key, value string) {
+		if value != "" {
+			pairs = append(pairs, key+"="+quoteConnValue(value))
+		}
+	}
+
+	add("host", cfg.host)
+	if cfg.port != 0 {
+		add("port", strconv.Itoa(cfg.port))
 	}
+	add("user", cfg.user)
+	add("password", cfg.password)
+	add("dbname", cfg.name)
+	add("sslmode", cfg.sslMode)
 
-	db.SetMaxOpenConns(app.config.db.maxOpenConns)
-	// Set the maximum number of idle connections in the pool. Again, passing a value // less than or equal to 0 will mean there is no limit. db.SetMaxIdleConns(cfg.db.maxIdleConns)
+	return strings.Join(pairs, " ")
+}
 
-	db.SetMaxIdleConns(app.config.db.maxIdleConns)
+var connValueEscaper = strings.NewReplacer(`\`, `\\`, `'`, `\'`)
 
-	duration, err := time.ParseDuration(app.config.db.maxIdleTime)
+// quoteConnValue quotes values that libpq style parsers would otherwise split, like passwords with spaces
+func quoteConnValue(value string) string {
+	if !strings.ContainsAny(value, ` '\`) {
+		return value
+	}
+	return "'" + connValueEscaper.Replace(value) + "'"
+}
+
+func (cfg dbConfig) connConfig() (*pgx.ConnConfig, error) {
+	connConfig, err := pgx.ParseConfig(cfg.connString())
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("invalid database config: %w", err)
+	}
+
+	// pgx sends these as startup parameters, so they hold for every connection in the pool
+	if _, found := connConfig.RuntimeParams["application_name"]; !found && cfg.applicationName != "" {
+		connConfig.RuntimeParams["application_name"] = cfg.applicationName
+	}
+
+	if _, found := connConfig.RuntimeParams["statement_timeout"]; !found && cfg.statementTimeout > 0 {
+		connConfig.RuntimeParams["statement_timeout"] = strconv.FormatInt(cfg.statementTimeout.Milliseconds(), 10)
 	}
-	// Set the maximum idle timeout.
-	db.SetConnMaxIdleTime(duration)
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
 
-	err = db.PingContext(ctx)
+	return connConfig, nil
+}
+
+// openDB connects to postgres, waiting for it to come up. Callers will need to ensure it's closed.
+func (app *application) openDB(cfg dbConfig) (*sql.DB, error) {
+	connConfig, err := cfg.connConfig()
 	if err != nil {
 		return nil, err
 	}
 
-	err = db.Ping()
+	db := stdlib.OpenDB(*connConfig)
+
+	// passing a value less than or equal to 0 means there is no limit
+	db.SetMaxOpenConns(cfg.maxOpenConns)
+	db.SetMaxIdleConns(cfg.maxIdleConns)
+	db.SetConnMaxIdleTime(cfg.maxIdleTime)
+	db.SetConnMaxLifetime(cfg.connMaxLifetime)
+
+	err = waitForDB(db.PingContext, cfg.connectTimeout, 500*time.Millisecond, 5*time.Second, func(attempt int, err error, delay time.Duration) {
+		app.logger.PrintInfo("waiting for database", map[string]string{
+			"attempt":  strconv.Itoa(attempt),
+			"error":    err.Error(),
+			"retry_in": delay.String(),
+		})
+	})
 	if err != nil {
-		fmt.Println("Failed to ping the database:", err)
+		db.Close()
+		return nil, err
 	}
 
 	return db, nil
 }
 
-func (cfg PostgressConfig) String() string {
-	return fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=%s",
-		cfg.Host, cfg.Port, cfg.User, cfg.Password, cfg.Database, cfg.SSLMODE)
-}
+// waitForDB pings until the database answers, doubling the delay between attempts from base up to max. It
+// gives up with the last error once another attempt would start after timeout has passed.
+func waitForDB(ping func(ctx context.Context) error, timeout, base, max time.Duration, onRetry func(attempt int, err error, delay time.Duration)) error {
+	deadline := time.Now().Add(timeout)
+	delay := base
+
+	for attempt := 1; ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		err := ping(ctx)
+		cancel()
+
+		if err == nil {
+			return nil
+		}
+
+		if time.Now().Add(delay).After(deadline) {
+			return fmt.Errorf("database unavailable after %d attempts: %w", attempt, err)
+		}
+
+		if onRetry != nil {
+			onRetry(attempt, err, delay)
+		}
+
+		time.Sleep(delay)
 
-func DefaultPostgesTestConfig() PostgressConfig {
-	return PostgressConfig{
-		Host:     viper.GetString("TEST_DATABASE_HOST"),
-		Port:     viper.GetString("TEST_DATABASE_PORT"),
-		User:     viper.GetString("TEST_DATABASE_USER"),
-		Password: viper.GetString("TEST_DATABASE_PASSWORD"),
-		Database: viper.GetString("TEST_DATABASE"),
-		SSLMODE:  "disable",
+		delay *= 2
+		if delay > max {
+			delay = max
+		}
 	}
 }
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestDBConfig_ConnString(t *testing.T) {
+	tests := []struct {
+		Name     string
+		cfg      dbConfig
+		expected string
+	}{
+		{
+			Name:     "Discrete fields",
+			cfg:      dbConfig{host: "db", port: 5432, user: "movie_api", password: "pa55word", name: "movies", sslMode: "require"},
+			expected: "host=db port=5432 user=movie_api password=pa55word dbname=movies sslmode=require",
+		},
+		{
+			Name:     "Quoted password",
+			cfg:      dbConfig{host: "db", password: `it's a pass\word`},
+			expected: `host=db password='it\'s a pass\\word'`,
+		},
+		{
+			Name:     "DSN wins",
+			cfg:      dbConfig{dsn: "postgres://movie_api@db/movies", host: "ignored"},
+			expected: "postgres://movie_api@db/movies",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			if got := tt.cfg.connString(); got != tt.expected {
+				t.Errorf("Unexpected connection string. Expected: %s, Got: %s", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestDBConfig_ConnConfig(t *testing.T) {
+	cfg := dbConfig{
+		host:             "db",
+		port:             5432,
+		user:             "movie_api",
+		password:         "it's a secret",
+		name:             "movies",
+		sslMode:          "disable",
+		applicationName:  "movie_api",
+		statementTimeout: 90 * time.Second,
+	}
+
+	connConfig, err := cfg.connConfig()
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+
+	if connConfig.Password != "it's a secret" || connConfig.Database != "movies" {
+		t.Errorf("Unexpected connection settings. Got password %q and database %q", connConfig.Password, connConfig.Database)
+	}
+
+	if got := connConfig.RuntimeParams["application_name"]; got != "movie_api" {
+		t.Errorf("Unexpected application_name. Expected: movie_api, Got: %s", got)
+	}
+
+	if got := connConfig.RuntimeParams["statement_timeout"]; got != "90000" {
+		t.Errorf("Unexpected statement_timeout. Expected: 90000, Got: %s", got)
+	}
+
+	// settings in the dsn are left alone
+	cfg.dsn = "postgres://movie_api@db/movies?sslmode=disable&application_name=worker"
+
+	connConfig, err = cfg.connConfig()
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+
+	if got := connConfig.RuntimeParams["application_name"]; got != "worker" {
+		t.Errorf("Unexpected application_name. Expected: worker, Got: %s", got)
+	}
+
+	if _, err := (dbConfig{dsn: "postgres://db:notaport/movies"}).connConfig(); err == nil {
+		t.Error("Expected an error for an invalid dsn")
+	}
+}
+
+func TestWaitForDB(t *testing.T) {
+	errRefused := errors.New("connection refused")
+
+	t.Run("Comes up", func(t *testing.T) {
+		calls := 0
+		ping := func(ctx context.Context) error {
+			calls++
+			if calls < 3 {
+				return errRefused
+			}
+			return nil
+		}
+
+		delays := []time.Duration{}
+		err := waitForDB(ping, time.Second, time.Millisecond, 3*time.Millisecond, func(attempt int, err error, delay time.Duration) {
+			delays = append(delays, delay)
+		})
+
+		if err != nil || calls != 3 {
+			t.Fatalf("Expected success on the third attempt, Got: %d attempts (%v)", calls, err)
+		}
+
+		if len(delays) != 2 || delays[0] != time.Millisecond || delays[1] != 2*time.Millisecond {
+			t.Errorf("Unexpected backoff. Expected: [1ms 2ms], Got: %v", delays)
+		}
+	})
+
+	t.Run("Never comes up", func(t *testing.T) {
+		ping := func(ctx context.Context) error { return errRefused }
+
+		err := waitForDB(ping, 20*time.Millisecond, time.Millisecond, 5*time.Millisecond, nil)
+		if !errors.Is(err, errRefused) {
+			t.Errorf("Unexpected error. Expected: %v, Got: %v", errRefused, err)
+		}
+	})
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"movie_api/internal/ratelimit"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// routeLimit applies its own policy to every path under prefix, instead of the default limiter settings
+type routeLimit struct {
+	prefix string
+	policy ratelimit.Policy
+}
+
+// parseRouteLimits reads a space separated list of prefix=rps:burst, e.g. "/v1/tokens/=0.2:5"
+func parseRouteLimits(val string) ([]routeLimit, error) {
+	var limits []routeLimit
+
+	for _, field := range strings.Fields(val) {
+		prefix, policy, found := strings.Cut(field, "=")
+		rps, burst, found2 := strings.Cut(policy, ":")
+		if !found || !found2 || !strings.HasPrefix(prefix, "/") {
+			return nil, fmt.Errorf("malformed route limit %q, expected prefix=rps:burst", field)
+		}
+
+		rate, err := strconv.ParseFloat(rps, 64)
+		if err != nil || rate <= 0 {
+			return nil, fmt.Errorf("malformed route limit %q, rps must be a positive number", field)
+		}
+
+		b, err := strconv.Atoi(burst)
+		if err != nil || b < 1 {
+			return nil, fmt.Errorf("malformed route limit %q, burst must be a positive integer", field)
+		}
+
+		limits = append(limits, routeLimit{
+			prefix: prefix,
+			policy: ratelimit.Policy{Name: prefix, Rate: rate, Burst: b},
+		})
+	}
+
+	return limits, nil
+}
+
+// rateLimitPolicy picks the policy for a request, the longest matching route prefix wins
+func (app *application) rateLimitPolicy(r *http.Request) ratelimit.Policy {
+	policy := ratelimit.Policy{
+		Name:  "default",
+		Rate:  app.config.limiter.rps,
+		Burst: app.config.limiter.burst,
+	}
+
+	longest := 0
+
+	for _, route := range app.config.limiter.routes {
+		if strings.HasPrefix(r.URL.Path, route.prefix) && len(route.prefix) > longest {
+			policy = route.policy
+			longest = len(route.prefix)
+		}
+	}
+
+	return policy
+}
+
+// allowRequest spends a token from the bucket for key, writing the RateLimit headers. It sends the response
+// itself and returns false when the request is refused.
+func (app *application) allowRequest(w http.ResponseWriter, r *http.Request, key string, policy ratelimit.Policy) bool {
+	result, err := app.limiter.Allow(r.Context(), policy.Name+":"+key, policy)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+
+	// a later stage overwrites these, the headers describe the most specific bucket the request was counted in
+	setRateLimitHeaders(w, result)
+
+	if !result.Allowed {
+		app.rateLimitExceededResponse(w, r)
+		return false
+	}
+
+	return true
+}
+
+// setRateLimitHeaders writes the headers from the IETF RateLimit header fields draft, plus Retry-After once limited
+func setRateLimitHeaders(w http.ResponseWriter, result ratelimit.Result) {
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	w.Header().Set("RateLimit-Reset", strconv.Itoa(int(math.Ceil(result.Reset.Seconds()))))
+
+	if !result.Allowed {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(result.RetryAfter.Seconds()))))
+	}
+}
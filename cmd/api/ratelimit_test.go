@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"movie_api/internal/data"
+	"movie_api/internal/jsonlog"
+	"movie_api/internal/ratelimit"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestRateLimitStages(t *testing.T) {
+	app := &application{
+		logger:  jsonlog.New(os.Stdout, jsonlog.LevelFatal),
+		models:  data.NewMemoryModels(),
+		limiter: ratelimit.NewMemory(),
+	}
+	app.config.limiter.enabled = true
+	app.config.limiter.rps = 0.001
+	app.config.limiter.burst = 2
+	app.config.limiter.userMultiplier = 2
+	app.config.limiter.privilegedMultiplier = 3
+
+	// both sign in with session tokens, only bob can write movies
+	newToken := func(email string, permissions ...string) string {
+		user := &data.User{Name: "Test", Email: email, Activated: true}
+		if err := user.Password.Set("pa55word1234"); err != nil {
+			t.Fatalf("Failed to set password: %v", err)
+		}
+		if err := app.models.Users.Insert(context.Background(), user); err != nil {
+			t.Fatalf("Failed to insert user: %v", err)
+		}
+		if err := app.models.Permissions.AddForUser(context.Background(), user.ID, permissions...); err != nil {
+			t.Fatalf("Failed to add permissions: %v", err)
+		}
+
+		token, err := app.models.Tokens.New(context.Background(), user.ID, time.Hour, data.ScopeAuthentication)
+		if err != nil {
+			t.Fatalf("Failed to create token: %v", err)
+		}
+		return token.Plaintext
+	}
+
+	alice := newToken("alice@example.com", "movies:read")
+	bob := newToken("bob@example.com", "movies:read", "movies:write")
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	handler := app.rateLimitIP(app.authenticate(app.rateLimitUser(ok)))
+
+	tests := []struct {
+		Name           string
+		remoteAddr     string
+		token          string
+		expectedStatus int
+	}{
+		// guessed tokens are turned away by authenticate, and only get the anonymous quota of their ip
+		{"First bad token", "10.0.0.1:1234", "ABCDEFGHIJKLMNOPQRSTUVWXYZ", http.StatusUnauthorized},
+		{"Second bad token", "10.0.0.1:1234", "ABCDEFGHIJKLMNOPQRSTUVWXYZ", http.StatusUnauthorized},
+		{"Bad token over the ip limit", "10.0.0.1:1234", "ABCDEFGHIJKLMNOPQRSTUVWXYZ", http.StatusTooManyRequests},
+		{"Anonymous from the same ip", "10.0.0.1:1234", "", http.StatusTooManyRequests},
+		// a valid token isn't held to the anonymous quota of its ip
+		{"Valid token from the same ip", "10.0.0.1:1234", alice, http.StatusOK},
+		// the user bucket is separate from the ip one and follows the user between addresses
+		{"Second valid token", "10.0.0.2:1234", alice, http.StatusOK},
+		{"Third valid token", "10.0.0.3:1234", alice, http.StatusOK},
+		{"Fourth valid token", "10.0.0.4:1234", alice, http.StatusOK},
+		{"Valid token over the user limit", "10.0.0.5:1234", alice, http.StatusTooManyRequests},
+		{"Anonymous from a fresh ip", "10.0.0.5:1234", "", http.StatusOK},
+		// a session token with movies:write gets the privileged tier, not just the user one
+		{"First privileged token", "10.0.1.1:1234", bob, http.StatusOK},
+		{"Second privileged token", "10.0.1.2:1234", bob, http.StatusOK},
+		{"Third privileged token", "10.0.1.3:1234", bob, http.StatusOK},
+		{"Fourth privileged token", "10.0.1.4:1234", bob, http.StatusOK},
+		{"Fifth privileged token", "10.0.1.5:1234", bob, http.StatusOK},
+		{"Sixth privileged token", "10.0.1.6:1234", bob, http.StatusOK},
+		{"Privileged token over the limit", "10.0.1.7:1234", bob, http.StatusTooManyRequests},
+	}
+
+	// the cases share one limiter and run in order, each builds on the state left by the last
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/v1/movies", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.token != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("Unexpected status code. Expected: %d, Got: %d", tt.expectedStatus, w.Code)
+			}
+		})
+	}
+}
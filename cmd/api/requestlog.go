@@ -0,0 +1,78 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// maxRequestIDLength stops a client stuffing arbitrary amounts of text into every log line
+const maxRequestIDLength = 128
+
+// logRequests tags each request with an id, taking the caller's X-Request-ID when it looks sane so a request
+// can be followed across services, and writes one access log line once the response has gone out
+func (app *application) logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+
+		r = app.contextSetRequestID(r, id)
+		state, r := app.contextRequestState(r)
+
+		w.Header().Set("X-Request-ID", id)
+
+		mw := newMetricsResponseWriter(w)
+		next.ServeHTTP(mw, r)
+
+		if app.logger == nil {
+			return
+		}
+
+		properties := map[string]string{
+			"request_id":  id,
+			"method":      r.Method,
+			"route":       state.route,
+			"status":      strconv.Itoa(mw.statusCode),
+			"bytes":       strconv.Itoa(mw.bytesWritten),
+			"duration_ms": strconv.FormatFloat(float64(time.Since(start).Microseconds())/1000, 'f', 3, 64),
+			"client_ip":   state.clientIP,
+		}
+
+		if state.userID != 0 {
+			properties["user_id"] = strconv.FormatInt(state.userID, 10)
+		}
+
+		app.logger.PrintInfo("request", properties)
+	})
+}
+
+// validRequestID allows the characters common id formats use, uuids, hex and base64url among them, and
+// nothing that could break a log line or a response header
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':', c == '+', c == '/', c == '=':
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
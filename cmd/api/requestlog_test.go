@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"movie_api/internal/data"
+	"movie_api/internal/jsonlog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLogRequests(t *testing.T) {
+	var buf bytes.Buffer
+	app := &application{logger: jsonlog.New(&buf, jsonlog.LevelInfo)}
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = app.contextSetUser(r, &data.User{ID: 7})
+		app.notFoundResponse(w, r)
+	})
+
+	tests := []struct {
+		Name       string
+		incoming   string
+		expectSame bool
+	}{
+		{"Accepts a caller's id", "3f2c1d9e-6a7b-4c8d-9e0f-112233445566", true},
+		{"Generates a missing id", "", false},
+		{"Replaces an unsafe id", "bad id\nwith a newline", false},
+		{"Replaces an oversized id", strings.Repeat("a", maxRequestIDLength+1), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			buf.Reset()
+
+			r := httptest.NewRequest(http.MethodGet, "/v1/missing", nil)
+			if tt.incoming != "" {
+				r.Header.Set("X-Request-ID", tt.incoming)
+			}
+
+			w := httptest.NewRecorder()
+			app.logRequests(next).ServeHTTP(w, r)
+
+			id := w.Header().Get("X-Request-ID")
+			if id == "" {
+				t.Fatal("Expected an X-Request-ID response header")
+			}
+
+			if (id == tt.incoming) != tt.expectSame {
+				t.Errorf("Unexpected request id. Incoming: %q, Got: %q", tt.incoming, id)
+			}
+
+			var body map[string]any
+			_ = json.Unmarshal(w.Body.Bytes(), &body)
+			if body["request_id"] != id {
+				t.Errorf("Expected the error body to carry the request id. Got: %v", body["request_id"])
+			}
+
+			var line struct {
+				Message    string            `json:"message"`
+				Properties map[string]string `json:"properties"`
+			}
+			if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+				t.Fatalf("Failed to decode access log line: %v", err)
+			}
+
+			if line.Message != "request" || line.Properties["request_id"] != id || line.Properties["status"] != "404" ||
+				line.Properties["user_id"] != "7" || line.Properties["route"] != "unmatched" || line.Properties["bytes"] == "0" {
+				t.Errorf("Unexpected access log line. Got: %+v", line)
+			}
+		})
+	}
+}
@@ -2,12 +2,12 @@ package main
 
 import (
 	"expvar"
-	"github.com/julienschmidt/httprouter"
+	"movie_api/internal/metrics"
 	"net/http"
 )
 
 func (app *application) routes() http.Handler {
-	router := httprouter.New()
+	router := newPatternRouter()
 
 	router.NotFound = http.HandlerFunc(app.notFoundResponse)
 
@@ -24,12 +24,28 @@ func (app *application) routes() http.Handler {
 	router.HandlerFunc(http.MethodPost, "/v1/users", app.registerUserHandler)
 	router.HandlerFunc(http.MethodPut, "/v1/users/activated", app.activateUserHandler)
 	router.HandlerFunc(http.MethodPut, "/v1/users/password", app.updatePasswordHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/users/unlocked", app.unlockUserHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/users/restored", app.cancelDeletionHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/users/me", app.requireActivatedUser(app.showCurrentUserHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/users/me", app.requireActivatedUser(app.updateCurrentUserHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me", app.requireActivatedUser(app.deleteCurrentUserHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/export", app.requireActivatedUser(app.exportCurrentUserHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/users/me/password", app.requireActivatedUser(app.changePasswordHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/users/me/email", app.requireActivatedUser(app.requestEmailChangeHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/users/me/email/confirmed", app.confirmEmailChangeHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/users/totp", app.requireActivatedUser(app.enrolTOTPHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/users/totp", app.requireActivatedUser(app.confirmTOTPHandler))
 
 	router.HandlerFunc(http.MethodPost, "/v1/tokens/activation", app.createActivationTokenHandler)
 	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler)
 	router.HandlerFunc(http.MethodPost, "/v1/tokens/password-reset", app.createPasswordResetTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/mfa", app.createMFAAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/invite", app.requirePermission("users:invite", app.createInviteTokenHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/magic-link", app.createMagicLinkTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/magic-link/exchange", app.exchangeMagicLinkTokenHandler)
 
-	router.Handler(http.MethodGet, "/debug/vars", expvar.Handler())
+	router.HandlerFunc(http.MethodGet, "/debug/vars", app.requirePermission("debug:read", expvar.Handler().ServeHTTP))
+	router.HandlerFunc(http.MethodGet, "/metrics", app.requirePermission("debug:read", metrics.DefaultRegistry.ServeHTTP))
 
-	return app.metrics(app.recoverPanic(app.enableCORS(app.rateLimit(app.authenticate(router)))))
+	return app.logRequests(app.metrics(app.traceRequests(app.secureHeaders(app.recoverPanic(app.resolveClientIP(app.enableCORS(app.rateLimitIP(app.authenticate(app.rateLimitUser(router))))))))))
 }
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSecureHeaders(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	t.Run("Enabled", func(t *testing.T) {
+		app := &application{}
+		app.config.security = securityHeaders{
+			enabled:               true,
+			contentSecurityPolicy: "default-src 'none'",
+			referrerPolicy:        "no-referrer",
+			hstsMaxAge:            time.Hour,
+			hstsIncludeSubdomains: true,
+		}
+
+		w := httptest.NewRecorder()
+		app.secureHeaders(next).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		expected := map[string]string{
+			"X-Content-Type-Options":    "nosniff",
+			"X-Frame-Options":           "DENY",
+			"Content-Security-Policy":   "default-src 'none'",
+			"Referrer-Policy":           "no-referrer",
+			"Strict-Transport-Security": "max-age=3600; includeSubDomains",
+		}
+
+		for name, value := range expected {
+			if got := w.Header().Get(name); got != value {
+				t.Errorf("Unexpected %s header. Expected: %s, Got: %s", name, value, got)
+			}
+		}
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		app := &application{}
+
+		w := httptest.NewRecorder()
+		app.secureHeaders(next).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		if got := w.Header().Get("X-Content-Type-Options"); got != "" {
+			t.Errorf("Expected no security headers, got X-Content-Type-Options: %s", got)
+		}
+	})
+}
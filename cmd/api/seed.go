@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"movie_api/internal/data"
+	"movie_api/internal/validator"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const defaultFixture = "fixtures/dev.yaml"
+
+// fixture describes a development database. The yaml form is converted to json before decoding, so both share
+// the json tags and the "107 mins" runtime format the api itself uses.
+type fixture struct {
+	// Admin is activated and given every permission
+	Admin  *fixtureUser   `json:"admin"`
+	Users  []fixtureUser  `json:"users"`
+	Movies []fixtureMovie `json:"movies"`
+}
+
+type fixtureUser struct {
+	Name        string           `json:"name"`
+	Email       string           `json:"email"`
+	Password    string           `json:"password"`
+	Activated   bool             `json:"activated"`
+	Permissions data.Permissions `json:"permissions"`
+}
+
+type fixtureMovie struct {
+	Title   string       `json:"title"`
+	Year    int32        `json:"year"`
+	Runtime data.Runtime `json:"runtime"`
+	Genres  []string     `json:"genres"`
+}
+
+func loadFixture(path string) (*fixture, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		var doc any
+		err = yaml.Unmarshal(contents, &doc)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+
+		contents, err = json.Marshal(doc)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	case ".json":
+	default:
+		return nil, fmt.Errorf("%s: fixtures must be .yaml, .yml or .json", path)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(contents))
+	dec.DisallowUnknownFields()
+
+	var f fixture
+	err = dec.Decode(&f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return &f, nil
+}
+
+// seedResult counts what a seed run created, anything already present is left as it was
+type seedResult struct {
+	usersCreated   int
+	usersExisting  int
+	moviesCreated  int
+	moviesExisting int
+}
+
+// seed loads a fixture through the models so the same validation as the api applies. It can be run repeatedly,
+// users are matched on email and movies on title and year, and existing users only gain missing permissions and
+// are activated when the fixture says they should be.
+// Everything happens in one transaction, so a fixture that fails part way leaves nothing behind.
+func (app *application) seed(ctx context.Context, f *fixture) (seedResult, error) {
+	var result seedResult
+
+	err := app.models.WithTx(ctx, func(tx data.Models) error {
+		// WithTx may retry, so each attempt starts counting from scratch
+		result = seedResult{}
+
+		users := f.Users
+		var admin *data.User
+
+		if f.Admin != nil {
+			// every permission the migrations have created, so new ones reach the admin without touching this
+			all, err := tx.Permissions.GetAll(ctx)
+			if err != nil {
+				return err
+			}
+
+			a := *f.Admin
+			a.Activated = true
+			a.Permissions = all
+			users = append([]fixtureUser{a}, users...)
+		}
+
+		for i, fu := range users {
+			user, created, err := seedUser(ctx, tx, fu)
+			if err != nil {
+				return err
+			}
+
+			if created {
+				result.usersCreated++
+			} else {
+				result.usersExisting++
+			}
+
+			if i == 0 && f.Admin != nil {
+				admin = user
+			}
+		}
+
+		for _, fm := range f.Movies {
+			movie := &data.Movie{Title: fm.Title, Year: fm.Year, Runtime: fm.Runtime, Genres: fm.Genres}
+			if admin != nil {
+				movie.CreatedBy = admin.ID
+			}
+
+			created, err := seedMovie(ctx, tx, movie)
+			if err != nil {
+				return err
+			}
+
+			if created {
+				result.moviesCreated++
+			} else {
+				result.moviesExisting++
+			}
+		}
+
+		return nil
+	})
+
+	return result, err
+}
+
+func seedUser(ctx context.Context, tx data.Models, fu fixtureUser) (*data.User, bool, error) {
+	user, err := tx.Users.GetByEmail(ctx, fu.Email)
+	created := false
+
+	switch {
+	case errors.Is(err, data.ErrRecordNotFound):
+		user = &data.User{Name: fu.Name, Email: fu.Email, Activated: fu.Activated}
+
+		err = user.Password.Set(fu.Password)
+		if err != nil {
+			return nil, false, err
+		}
+
+		v := validator.New()
+		if data.ValidateUser(v, user); !v.Valid() {
+			return nil, false, validationError("user "+fu.Email, v.Errors)
+		}
+
+		err = tx.Users.Insert(ctx, user)
+		if err != nil {
+			return nil, false, err
+		}
+		created = true
+	case err != nil:
+		return nil, false, err
+	case fu.Activated && !user.Activated:
+		// the admin is only useful activated, so one that registered but never confirmed is activated here
+		user.Activated = true
+
+		err = tx.Users.Update(ctx, user)
+		if err != nil {
+			return nil, false, fmt.Errorf("activating user %s: %w", fu.Email, err)
+		}
+	}
+
+	// adding a permission twice violates the primary key, so only ask for the missing ones
+	granted, err := tx.Permissions.GetAllForUser(ctx, user.ID)
+	if err != nil {
+		return nil, false, err
+	}
+
+	missing := []string{}
+	for _, code := range fu.Permissions {
+		if !granted.Include(code) {
+			missing = append(missing, code)
+		}
+	}
+
+	if len(missing) > 0 {
+		err = tx.Permissions.AddForUser(ctx, user.ID, missing...)
+		if err != nil {
+			return nil, false, err
+		}
+	}
+
+	return user, created, nil
+}
+
+func seedMovie(ctx context.Context, tx data.Models, movie *data.Movie) (bool, error) {
+	v := validator.New()
+	if data.ValidateMovie(v, movie); !v.Valid() {
+		return false, validationError("movie "+movie.Title, v.Errors)
+	}
+
+	_, err := tx.Movies.GetByTitle(ctx, movie.Title, movie.Year)
+	switch {
+	case err == nil:
+		return false, nil
+	case !errors.Is(err, data.ErrRecordNotFound):
+		return false, err
+	}
+
+	err = tx.Movies.Insert(ctx, movie)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func validationError(subject string, errs map[string]string) error {
+	keys := make([]string, 0, len(errs))
+	for key := range errs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	problems := make([]string, 0, len(keys))
+	for _, key := range keys {
+		problems = append(problems, key+" "+errs[key])
+	}
+
+	return fmt.Errorf("%s: %s", subject, strings.Join(problems, ", "))
+}
+
+// seedCommand runs `api seed [fixture]` against the configured database
+func (app *application) seedCommand(args []string) error {
+	if len(args) > 1 {
+		return errors.New("usage: api seed [FIXTURE]")
+	}
+
+	path := defaultFixture
+	if len(args) == 1 {
+		path = args[0]
+	}
+
+	f, err := loadFixture(path)
+	if err != nil {
+		return err
+	}
+
+	db, err := app.openDB(app.config.db)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	app.models = data.NewModels(db)
+
+	result, err := app.seed(context.Background(), f)
+	if err != nil {
+		return err
+	}
+
+	app.logger.PrintInfo("seeded database", map[string]string{
+		"fixture":         path,
+		"users_created":   strconv.Itoa(result.usersCreated),
+		"users_existing":  strconv.Itoa(result.usersExisting),
+		"movies_created":  strconv.Itoa(result.moviesCreated),
+		"movies_existing": strconv.Itoa(result.moviesExisting),
+	})
+
+	return nil
+}
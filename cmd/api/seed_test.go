@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"golang.org/x/crypto/bcrypt"
+	"movie_api/internal/data"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSeed(t *testing.T) {
+	defer func(previous data.PasswordHasher) { data.PreferredHasher = previous }(data.PreferredHasher)
+	data.PreferredHasher = data.BcryptHasher{Cost: bcrypt.MinCost}
+
+	f, err := loadFixture("../../fixtures/dev.yaml")
+	if err != nil {
+		t.Fatalf("Failed to load the development fixture: %v", err)
+	}
+
+	app := &application{models: data.NewMemoryModels()}
+	ctx := context.Background()
+
+	result, err := app.seed(ctx, f)
+	if err != nil {
+		t.Fatalf("Failed to seed: %v", err)
+	}
+
+	if result.usersCreated != 4 || result.moviesCreated != 5 {
+		t.Errorf("Unexpected first run. Expected: 4 users and 5 movies, Got: %+v", result)
+	}
+
+	// a second run finds everything already there
+	result, err = app.seed(ctx, f)
+	if err != nil {
+		t.Fatalf("Failed to seed again: %v", err)
+	}
+
+	if result.usersCreated != 0 || result.usersExisting != 4 || result.moviesCreated != 0 || result.moviesExisting != 5 {
+		t.Errorf("Unexpected second run. Expected nothing new, Got: %+v", result)
+	}
+
+	admin, err := app.models.Users.GetByEmail(ctx, "admin@example.com")
+	if err != nil || !admin.Activated {
+		t.Fatalf("Expected an activated admin, Got: %+v (%v)", admin, err)
+	}
+
+	all, err := app.models.Permissions.GetAll(ctx)
+	if err != nil {
+		t.Fatalf("Failed to list permissions: %v", err)
+	}
+
+	permissions, _ := app.models.Permissions.GetAllForUser(ctx, admin.ID)
+	for _, code := range all {
+		if !permissions.Include(code) {
+			t.Errorf("Expected the admin to have %s, Got: %v", code, permissions)
+		}
+	}
+
+	carol, err := app.models.Users.GetByEmail(ctx, "carol@example.com")
+	if err != nil || carol.Activated {
+		t.Errorf("Expected carol to be left unactivated, Got: %+v (%v)", carol, err)
+	}
+
+	authored, _ := app.models.Movies.GetAllForCreator(ctx, admin.ID)
+	if len(authored) != 5 {
+		t.Errorf("Expected the admin to be credited with every movie, Got: %d", len(authored))
+	}
+}
+
+func TestSeed_Invalid(t *testing.T) {
+	defer func(previous data.PasswordHasher) { data.PreferredHasher = previous }(data.PreferredHasher)
+	data.PreferredHasher = data.BcryptHasher{Cost: bcrypt.MinCost}
+
+	path := filepath.Join(t.TempDir(), "invalid.json")
+	fixtureJSON := `{
+		"users": [{"name": "Dave", "email": "dave@example.com", "password": "Granite-Willow-Comet-83"}],
+		"movies": [{"title": "Tomorrow", "year": 3000, "runtime": "90 mins", "genres": ["sci-fi"]}]
+	}`
+	if err := os.WriteFile(path, []byte(fixtureJSON), 0o600); err != nil {
+		t.Fatalf("Failed to write fixture: %v", err)
+	}
+
+	f, err := loadFixture(path)
+	if err != nil {
+		t.Fatalf("Failed to load fixture: %v", err)
+	}
+
+	app := &application{models: data.NewMemoryModels()}
+
+	_, err = app.seed(context.Background(), f)
+	if err == nil || !strings.Contains(err.Error(), "movie Tomorrow: year must not be in the future") {
+		t.Fatalf("Unexpected error. Expected a validation error for the movie, Got: %v", err)
+	}
+
+	// the whole fixture is one transaction, the valid user goes too
+	if _, err := app.models.Users.GetByEmail(context.Background(), "dave@example.com"); err == nil {
+		t.Error("Expected the user to be rolled back")
+	}
+}
+
+// an admin email that registered but never activated is taken over, the seeded admin has to be able to log in
+func TestSeed_ActivatesExistingAdmin(t *testing.T) {
+	defer func(previous data.PasswordHasher) { data.PreferredHasher = previous }(data.PreferredHasher)
+	data.PreferredHasher = data.BcryptHasher{Cost: bcrypt.MinCost}
+
+	app := &application{models: data.NewMemoryModels()}
+	ctx := context.Background()
+
+	existing := &data.User{Name: "Admin", Email: "admin@example.com"}
+	if err := existing.Password.Set("Granite-Willow-Comet-83"); err != nil {
+		t.Fatalf("Failed to set password: %v", err)
+	}
+	if err := app.models.Users.Insert(ctx, existing); err != nil {
+		t.Fatalf("Failed to insert user: %v", err)
+	}
+
+	f := &fixture{Admin: &fixtureUser{Name: "Admin", Email: "admin@example.com", Password: "Granite-Willow-Comet-83"}}
+
+	result, err := app.seed(ctx, f)
+	if err != nil {
+		t.Fatalf("Failed to seed: %v", err)
+	}
+
+	if result.usersCreated != 0 || result.usersExisting != 1 {
+		t.Errorf("Unexpected result. Expected the existing admin to be reused, Got: %+v", result)
+	}
+
+	admin, err := app.models.Users.Get(ctx, existing.ID)
+	if err != nil || !admin.Activated {
+		t.Fatalf("Expected the existing admin to be activated, Got: %+v (%v)", admin, err)
+	}
+
+	all, err := app.models.Permissions.GetAll(ctx)
+	if err != nil {
+		t.Fatalf("Failed to list permissions: %v", err)
+	}
+
+	permissions, _ := app.models.Permissions.GetAllForUser(ctx, admin.ID)
+	for _, code := range all {
+		if !permissions.Include(code) {
+			t.Errorf("Expected the admin to have %s, Got: %v", code, permissions)
+		}
+	}
+}
+
+// movies are matched on exact title and year, titles the full text search can't find must not be seeded twice
+func TestSeed_MatchesExactMovies(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "movies.yaml")
+	fixtureYAML := `movies:
+  - {title: "!!!", year: 2000, runtime: "90 mins", genres: [documentary]}
+  - {title: The Thing, year: 1982, runtime: "109 mins", genres: [horror]}
+  - {title: The Thing, year: 2011, runtime: "103 mins", genres: [horror]}
+`
+	if err := os.WriteFile(path, []byte(fixtureYAML), 0o600); err != nil {
+		t.Fatalf("Failed to write fixture: %v", err)
+	}
+
+	f, err := loadFixture(path)
+	if err != nil {
+		t.Fatalf("Failed to load fixture: %v", err)
+	}
+
+	app := &application{models: data.NewMemoryModels()}
+
+	for _, expected := range []seedResult{{moviesCreated: 3}, {moviesExisting: 3}} {
+		result, err := app.seed(context.Background(), f)
+		if err != nil {
+			t.Fatalf("Failed to seed: %v", err)
+		}
+
+		if result != expected {
+			t.Errorf("Unexpected result. Expected: %+v, Got: %+v", expected, result)
+		}
+	}
+}
+
+func TestLoadFixture_UnknownField(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "typo.yaml")
+	if err := os.WriteFile(path, []byte("movie:\n  - title: Moana\n"), 0o600); err != nil {
+		t.Fatalf("Failed to write fixture: %v", err)
+	}
+
+	if _, err := loadFixture(path); err == nil {
+		t.Error("Expected an error for an unknown field")
+	}
+}
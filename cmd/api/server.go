@@ -5,9 +5,11 @@ import (
 	"errors"
 	"fmt"
 	"movie_api/internal/data"
+	"movie_api/internal/ratelimit"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 	"time"
 )
@@ -21,6 +23,18 @@ func (app *application) serve() error {
 		WriteTimeout: 10 * time.Second,
 	}
 
+	var adminSrv *http.Server
+
+	if app.config.admin.addr != "" {
+		adminSrv = &http.Server{
+			Handler:     app.adminRoutes(),
+			IdleTimeout: time.Minute,
+			ReadTimeout: 5 * time.Second,
+			// long enough for a 30 second cpu profile
+			WriteTimeout: time.Minute,
+		}
+	}
+
 	shutDownError := make(chan error)
 
 	go func() {
@@ -35,12 +49,17 @@ func (app *application) serve() error {
 			"signal": s.String(),
 		})
 
+		app.shuttingDown.Store(true)
+
 		// in flight requests have a 30 second grace period
 		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 
 		defer cancel()
 
 		err := srv.Shutdown(ctx)
+		if adminSrv != nil {
+			err = errors.Join(err, adminSrv.Shutdown(ctx))
+		}
 		if err != nil {
 			shutDownError <- err
 		}
@@ -50,26 +69,67 @@ func (app *application) serve() error {
 		})
 
 		app.wg.Wait()
+
+		// background emails have been traced by now, flush their spans too
+		if app.tracer != nil {
+			err = app.tracer.Shutdown(ctx)
+			if err != nil {
+				app.logger.PrintError(err, nil)
+			}
+		}
+
 		shutDownError <- nil
 	}()
 
-	dbConfig := DefaultPostgesTestConfig()
-
-	db, err := app.Open(dbConfig)
+	db, err := app.openDB(app.config.db)
 	if err != nil {
-		app.logger.PrintFatal(err, nil)
+		return err
 	}
 	defer db.Close()
 
-	err = db.Ping()
-	if err != nil {
-		fmt.Println("Failed to ping the database:", err)
+	if app.config.db.migrateOnStart {
+		err = app.migrateUp(db)
+		if err != nil {
+			return err
+		}
 	}
 
+	app.db = db
 	app.models = data.NewModels(db)
 
+	registerDBMetrics(db)
+
+	switch app.config.limiter.store {
+	case "postgres":
+		app.limiter = ratelimit.NewPostgres(db, func(err error) {
+			app.logger.PrintError(err, map[string]string{"component": "ratelimit"})
+		})
+	default:
+		app.limiter = ratelimit.NewMemory()
+	}
+
 	app.logger.PrintInfo("Connected to db", nil)
 
+	go app.purgeDeletedAccounts()
+
+	if adminSrv != nil {
+		listener, err := adminListener(app.config.admin.addr)
+		if err != nil {
+			return err
+		}
+
+		app.logger.PrintInfo("starting admin server", map[string]string{
+			"addr": listener.Addr().String(),
+		})
+
+		go func() {
+			err := adminSrv.Serve(listener)
+			if !errors.Is(err, http.ErrServerClosed) {
+				app.logger.PrintError(err, nil)
+			}
+		}()
+	}
+
 	app.logger.PrintInfo("starting server", map[string]string{
 		"addr": srv.Addr,
 		"env":  app.config.env,
@@ -91,3 +151,19 @@ func (app *application) serve() error {
 
 	return nil
 }
+
+// purgeDeletedAccounts hard deletes accounts once their deletion grace period has passed
+func (app *application) purgeDeletedAccounts() {
+	for {
+		purged, err := app.models.Deletions.PurgeDue(context.Background())
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		} else if purged > 0 {
+			app.logger.PrintInfo("purged deleted accounts", map[string]string{
+				"count": strconv.Itoa(purged),
+			})
+		}
+
+		time.Sleep(time.Hour)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"movie_api/internal/data"
+	"sync"
+	"time"
+)
+
+// loginThrottle tracks failed logins per client IP. Per account state lives in the database so it survives
+// restarts, but IPs are cheap to lose and are kept in memory the same way rateLimit does.
+type loginThrottle struct {
+	mu          sync.Mutex
+	clients     map[string]*loginFailures
+	maxFailures int
+	blockFor    time.Duration
+	delayBase   time.Duration
+	delayMax    time.Duration
+}
+
+type loginFailures struct {
+	count        int
+	lastFailedAt time.Time
+	blockedUntil time.Time
+}
+
+func newLoginThrottle(maxFailures int, blockFor, delayBase, delayMax time.Duration) *loginThrottle {
+	t := &loginThrottle{
+		clients:     make(map[string]*loginFailures),
+		maxFailures: maxFailures,
+		blockFor:    blockFor,
+		delayBase:   delayBase,
+		delayMax:    delayMax,
+	}
+
+	go func() {
+		for {
+			time.Sleep(time.Minute)
+
+			t.mu.Lock()
+			for ip, client := range t.clients {
+				if time.Now().After(client.blockedUntil) && time.Since(client.lastFailedAt) > t.blockFor {
+					delete(t.clients, ip)
+				}
+			}
+			t.mu.Unlock()
+		}
+	}()
+
+	return t
+}
+
+// retryAfter returns how long the ip has to wait before trying again, zero if it may try now
+func (t *loginThrottle) retryAfter(ip string) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	client, found := t.clients[ip]
+	if !found {
+		return 0
+	}
+
+	next := client.lastFailedAt.Add(data.Backoff(client.count, t.delayBase, t.delayMax))
+	if client.blockedUntil.After(next) {
+		next = client.blockedUntil
+	}
+
+	return time.Until(next)
+}
+
+func (t *loginThrottle) recordFailure(ip string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	client, found := t.clients[ip]
+	if !found {
+		client = &loginFailures{}
+		t.clients[ip] = client
+	}
+
+	client.count++
+	client.lastFailedAt = time.Now()
+
+	if client.count >= t.maxFailures {
+		client.count = 0
+		client.blockedUntil = time.Now().Add(t.blockFor)
+	}
+}
+
+// flush forgets every failure, unblocking all ips
+func (t *loginThrottle) flush() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.clients = make(map[string]*loginFailures)
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoginThrottle(t *testing.T) {
+	throttle := newLoginThrottle(3, time.Minute, time.Second, 10*time.Second)
+
+	if wait := throttle.retryAfter("1.2.3.4"); wait != 0 {
+		t.Errorf("Expected an unknown ip to be allowed, got a wait of %s", wait)
+	}
+
+	throttle.recordFailure("1.2.3.4")
+
+	if wait := throttle.retryAfter("1.2.3.4"); wait <= 0 || wait > time.Second {
+		t.Errorf("Expected a wait of up to a second after one failure, got %s", wait)
+	}
+
+	throttle.recordFailure("1.2.3.4")
+	throttle.recordFailure("1.2.3.4")
+
+	if wait := throttle.retryAfter("1.2.3.4"); wait <= 10*time.Second {
+		t.Errorf("Expected the ip to be blocked for the full duration, got a wait of %s", wait)
+	}
+
+	if wait := throttle.retryAfter("5.6.7.8"); wait != 0 {
+		t.Errorf("Expected other ips to be unaffected, got a wait of %s", wait)
+	}
+}
@@ -2,9 +2,15 @@ package main
 
 import (
 	"errors"
+	"fmt"
 	"movie_api/internal/data"
+	"movie_api/internal/jwt"
+	"movie_api/internal/ratelimit"
+	"movie_api/internal/totp"
 	"movie_api/internal/validator"
 	"net/http"
+	"net/url"
+	"strings"
 	"time"
 )
 
@@ -22,17 +28,29 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 	v := validator.New()
 
 	data.ValidateEmail(v, input.Email)
-	data.ValidatePasswordPlaintext(v, input.Email)
+	data.ValidatePasswordPlaintext(v, input.Password)
 
 	if !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	user, err := app.models.Users.GetByEmail(input.Email)
+	ip := app.contextGetClientIP(r)
+
+	if app.config.login.enabled {
+		if wait := app.loginThrottle.retryAfter(ip); wait > 0 {
+			app.tooManyLoginAttemptsResponse(w, r, wait)
+			return
+		}
+	}
+
+	user, err := app.models.Users.GetByEmail(r.Context(), input.Email)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
+			if app.config.login.enabled {
+				app.loginThrottle.recordFailure(ip)
+			}
 			app.invalidCredentialResponse(w, r)
 
 		default:
@@ -41,6 +59,13 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 		return
 	}
 
+	// until the password is checked the response mustn't differ from an unknown email's, or lockouts would
+	// tell anyone which addresses have accounts
+	lockout, allowed := app.loginAllowed(w, r, user, true)
+	if !allowed {
+		return
+	}
+
 	match, err := user.Password.Matches(input.Password)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -48,11 +73,309 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 	}
 
 	if !match {
+		if app.config.login.enabled {
+			err = app.recordLoginFailure(r, user)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+		}
+		app.invalidCredentialResponse(w, r)
+		return
+	}
+
+	// with 2FA the failures are only cleared once the code is right too, otherwise knowing the password would
+	// be enough to reset the count and keep guessing codes
+	if lockout != nil && lockout.FailedAttempts > 0 && !user.TOTPEnabled {
+		err = app.models.Lockouts.Reset(r.Context(), user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	// the plaintext is only ever at hand here, so this is the one chance to move an old hash onto the preferred hasher
+	if user.Password.NeedsRehash() {
+		err = user.Password.Set(input.Password)
+		if err == nil {
+			err = app.models.Users.Update(r.Context(), user)
+		}
+		// a failed upgrade shouldn't stop the login, it will be tried again next time
+		if err != nil {
+			app.logError(r, err)
+		}
+	}
+
+	app.completeLogin(w, r, user)
+}
+
+// loginAllowed applies the account lockout and backoff before a credential is checked, sending the response
+// itself when the attempt is refused. With hideAccount set a refusal looks like bad credentials instead of
+// saying the account is locked. The lockout is nil when login protection is turned off.
+func (app *application) loginAllowed(w http.ResponseWriter, r *http.Request, user *data.User, hideAccount bool) (*data.Lockout, bool) {
+	if !app.config.login.enabled {
+		return nil, true
+	}
+
+	lockout, err := app.models.Lockouts.Get(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return nil, false
+	}
+
+	locked := lockout.Locked(time.Now())
+	wait := time.Until(lockout.NextAttempt(app.config.login.delayBase, app.config.login.delayMax))
+
+	switch {
+	case (locked || wait > 0) && hideAccount:
+		app.invalidCredentialResponse(w, r)
+		return nil, false
+	case locked:
+		app.accountLockedResponse(w, r)
+		return nil, false
+	case wait > 0:
+		app.tooManyLoginAttemptsResponse(w, r, wait)
+		return nil, false
+	}
+
+	return lockout, true
+}
+
+// completeLogin finishes any sign in once the first factor, a password or a magic link, has been checked
+func (app *application) completeLogin(w http.ResponseWriter, r *http.Request, user *data.User) {
+	// an account waiting to be purged can't be signed in to, only restored with the token mailed out for it
+	if user.DeletionPending {
+		app.invalidCredentialResponse(w, r)
+		return
+	}
+
+	// with 2FA enabled the first factor only earns a short-lived mfa token, which is exchanged at /v1/tokens/mfa
+	if user.TOTPEnabled {
+		token, err := app.models.Tokens.New(r.Context(), user.ID, 5*time.Minute, data.ScopeMFA)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		err = app.writeJson(w, http.StatusOK, envelope{"mfa_required": true, "mfa_token": token}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	token, err := app.issueAuthenticationToken(r, user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJson(w, http.StatusCreated, envelope{"authentication_token": token}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createMagicLinkTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// limited per address on top of the usual per client limits, so nobody can flood someone's inbox
+	policy := ratelimit.Policy{
+		Name:  "magic-link",
+		Rate:  float64(app.config.magicLink.limit) / app.config.magicLink.window.Seconds(),
+		Burst: app.config.magicLink.limit,
+	}
+
+	result, err := app.limiter.Allow(r.Context(), "magic-link:"+strings.ToLower(input.Email), policy)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !result.Allowed {
+		setRateLimitHeaders(w, result)
+		app.rateLimitExceededResponse(w, r)
+		return
+	}
+
+	// the response is the same whether or not the account exists, so this can't be used to look up emails
+	env := envelope{"message": "if an account exists for that email address, a sign in link will be sent to it"}
+
+	user, err := app.models.Users.GetByEmail(r.Context(), input.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			err = app.writeJson(w, http.StatusAccepted, env, nil)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+			}
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	token, err := app.models.Tokens.New(r.Context(), user.ID, app.config.magicLink.ttl, data.ScopeLogin)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.background(func() {
+		data := map[string]any{
+			"loginToken": token.Plaintext,
+			"loginURL":   app.config.magicLink.url + "?token=" + url.QueryEscape(token.Plaintext),
+			"ttlMinutes": int(app.config.magicLink.ttl.Minutes()),
+		}
+
+		err := app.mailer.Send(r.Context(), user.Email, "magic_link.tmpl", data)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	})
+
+	err = app.writeJson(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) exchangeMagicLinkTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateTokenPlainText(v, input.TokenPlaintext); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// consuming deletes the token in the same statement, so two requests racing with one link can't both win
+	userID, err := app.models.Tokens.Consume(r.Context(), data.ScopeLogin, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired sign in token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	user, err := app.models.Users.Get(r.Context(), userID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.completeLogin(w, r, user)
+}
+
+func (app *application) createMFAAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TokenPlaintext string `json:"token"`
+		Code           string `json:"code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	data.ValidateTokenPlainText(v, input.TokenPlaintext)
+	v.Check(input.Code != "", "code", "must be provided")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(r.Context(), data.ScopeMFA, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired mfa token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// a wrong code counts as a failed login, so codes can't be guessed any faster than passwords. Whoever
+	// holds an mfa token already knows the account exists, so the lock can be named.
+	lockout, allowed := app.loginAllowed(w, r, user, false)
+	if !allowed {
+		return
+	}
+
+	// a code stays inside the window for a while after it's used, so each time step is only accepted once.
+	// Anything that isn't a six digit code is treated as a one time recovery code.
+	var match bool
+	if step, valid := totp.Validate(user.TOTPSecret, input.Code, time.Now()); valid {
+		match, err = app.models.Users.AcceptTOTPStep(r.Context(), user.ID, step)
+	} else {
+		match, err = app.models.RecoveryCodes.Consume(r.Context(), user.ID, input.Code)
+	}
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !match {
+		if app.config.login.enabled {
+			err = app.recordLoginFailure(r, user)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+		}
 		app.invalidCredentialResponse(w, r)
 		return
 	}
 
-	token, err := app.models.Tokens.New(user.ID, 24*time.Hour, data.ScopeAuthentication)
+	if lockout != nil && lockout.FailedAttempts > 0 {
+		err = app.models.Lockouts.Reset(r.Context(), user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	err = app.models.Tokens.DeleteAllForUser(r.Context(), data.ScopeMFA, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	token, err := app.issueAuthenticationToken(r, user)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -64,6 +387,81 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 	}
 }
 
+// recordLoginFailure counts a bad password against both the ip and the account. When that failure locks
+// the account the owner is emailed an unlock token, so a locked out user isn't stuck waiting.
+func (app *application) recordLoginFailure(r *http.Request, user *data.User) error {
+	app.loginThrottle.recordFailure(app.contextGetClientIP(r))
+
+	lockout, err := app.models.Lockouts.RecordFailure(r.Context(), user.ID, app.config.login.maxFailures, app.config.login.lockoutDuration)
+	if err != nil {
+		return err
+	}
+
+	if !lockout.Locked(time.Now()) {
+		return nil
+	}
+
+	token, err := app.models.Tokens.New(r.Context(), user.ID, 24*time.Hour, data.ScopeUnlock)
+	if err != nil {
+		return err
+	}
+
+	app.background(func() {
+		data := map[string]any{
+			"unlockToken": token.Plaintext,
+			"lockedUntil": lockout.LockedUntil.UTC().Format(time.RFC1123),
+		}
+
+		err := app.mailer.Send(r.Context(), user.Email, "account_locked.tmpl", data)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	})
+
+	return nil
+}
+
+// issueAuthenticationToken hands out whichever kind of authentication token the server is configured for
+func (app *application) issueAuthenticationToken(r *http.Request, user *data.User) (*data.Token, error) {
+	if app.config.auth.tokenMode == "jwt" {
+		return app.newSignedToken(r, user)
+	}
+
+	return app.models.Tokens.NewSession(r.Context(), user.ID, 24*time.Hour, app.contextGetClientIP(r))
+}
+
+// newSignedToken issues a short-lived signed token carrying the permissions requirePermission needs, so requests
+// made with it never touch the tokens or permissions tables. authenticate still loads the user to check the
+// token generation it was signed with.
+func (app *application) newSignedToken(r *http.Request, user *data.User) (*data.Token, error) {
+	permissions, err := app.models.Permissions.GetAllForUser(r.Context(), user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	expiry := now.Add(app.config.auth.jwt.ttl)
+
+	plaintext, err := app.signer.Sign(jwt.Claims{
+		Subject:     user.ID,
+		Activated:   user.Activated,
+		Permissions: permissions,
+		Generation:  user.TokenGeneration,
+		IssuedAt:    now.Unix(),
+		Expiry:      expiry.Unix(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &data.Token{
+		Plaintext: plaintext,
+		UserID:    user.ID,
+		Expiry:    expiry,
+		Scope:     data.ScopeAuthentication,
+	}, nil
+}
+
 func (app *application) createPasswordResetTokenHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
 		Email string `json:"email"`
@@ -82,7 +480,7 @@ func (app *application) createPasswordResetTokenHandler(w http.ResponseWriter, r
 		return
 	}
 
-	user, err := app.models.Users.GetByEmail(input.Email)
+	user, err := app.models.Users.GetByEmail(r.Context(), input.Email)
 
 	if err != nil {
 		switch {
@@ -101,7 +499,7 @@ func (app *application) createPasswordResetTokenHandler(w http.ResponseWriter, r
 		return
 	}
 
-	token, err := app.models.Tokens.New(user.ID, 30*time.Minute, data.ScopePasswordReset)
+	token, err := app.models.Tokens.New(r.Context(), user.ID, 30*time.Minute, data.ScopePasswordReset)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -112,7 +510,7 @@ func (app *application) createPasswordResetTokenHandler(w http.ResponseWriter, r
 			"passwordResetToken": token.Plaintext,
 		}
 
-		err = app.mailer.Send(user.Email, "token_password_reset.tmpl", data)
+		err = app.mailer.Send(r.Context(), user.Email, "token_password_reset.tmpl", data)
 		if err != nil {
 			app.logger.PrintError(err, nil)
 		}
@@ -127,3 +525,83 @@ func (app *application) createPasswordResetTokenHandler(w http.ResponseWriter, r
 	}
 
 }
+
+func (app *application) createInviteTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email       string   `json:"email"`
+		Permissions []string `json:"permissions"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	invitation := &data.Invitation{
+		Email:       input.Email,
+		Permissions: input.Permissions,
+	}
+
+	v := validator.New()
+
+	if data.ValidateInvitation(v, invitation); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	inviter := app.contextGetUser(r)
+
+	// nobody can hand out permissions they don't hold themselves
+	permissions, err := app.models.Permissions.GetAllForUser(r.Context(), inviter.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	for _, code := range invitation.Permissions {
+		if !permissions.Include(code) {
+			v.AddError("permissions", fmt.Sprintf("you can't grant the %q permission", code))
+		}
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	_, err = app.models.Users.GetByEmail(r.Context(), invitation.Email)
+	switch {
+	case err == nil:
+		v.AddError("email", "a user with this email address already exists")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	case !errors.Is(err, data.ErrRecordNotFound):
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	token, err := app.models.Tokens.NewInvitation(r.Context(), inviter.ID, 7*24*time.Hour, invitation)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.background(func() {
+		data := map[string]any{
+			"inviteToken": token.Plaintext,
+		}
+
+		err := app.mailer.Send(r.Context(), invitation.Email, "user_invitation.tmpl", data)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	})
+
+	env := envelope{"message": "an invitation will be sent to " + invitation.Email}
+
+	err = app.writeJson(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"golang.org/x/crypto/bcrypt"
+	"movie_api/internal/data"
+	"movie_api/internal/jsonlog"
+	"movie_api/internal/ratelimit"
+	"movie_api/internal/totp"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCreateMFAAuthenticationTokenHandler(t *testing.T) {
+	app := &application{
+		logger:        jsonlog.New(os.Stdout, jsonlog.LevelFatal),
+		models:        data.NewMemoryModels(),
+		loginThrottle: newLoginThrottle(100, time.Minute, 0, 0),
+	}
+	app.config.login.enabled = true
+	app.config.login.maxFailures = 3
+	app.config.login.lockoutDuration = time.Minute
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		t.Fatalf("Failed to generate secret: %v", err)
+	}
+
+	user := &data.User{Name: "Alice", Email: "alice@example.com", Activated: true, TOTPSecret: secret, TOTPEnabled: true}
+	if err := user.Password.Set("pa55word1234"); err != nil {
+		t.Fatalf("Failed to set password: %v", err)
+	}
+	if err := app.models.Users.Insert(context.Background(), user); err != nil {
+		t.Fatalf("Failed to insert user: %v", err)
+	}
+
+	code, err := totp.Code(secret, time.Now())
+	if err != nil {
+		t.Fatalf("Failed to generate code: %v", err)
+	}
+
+	wrong := "000000"
+	if code == wrong {
+		wrong = "111111"
+	}
+
+	tests := []struct {
+		Name           string
+		code           string
+		expectedStatus int
+	}{
+		{"Valid code", code, http.StatusCreated},
+		// a replay counts as a failure like any other wrong code
+		{"Replayed code", code, http.StatusUnauthorized},
+		{"Wrong code", wrong, http.StatusUnauthorized},
+		{"Wrong code that locks the account", wrong, http.StatusUnauthorized},
+		{"Any code once locked", code, http.StatusLocked},
+	}
+
+	// the cases share one store and run in order, each builds on the state left by the last
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			token, err := app.models.Tokens.New(context.Background(), user.ID, 5*time.Minute, data.ScopeMFA)
+			if err != nil {
+				t.Fatalf("Failed to create mfa token: %v", err)
+			}
+
+			body := `{"token": "` + token.Plaintext + `", "code": "` + tt.code + `"}`
+			r := httptest.NewRequest(http.MethodPost, "/v1/tokens/mfa", strings.NewReader(body))
+
+			w := httptest.NewRecorder()
+			app.createMFAAuthenticationTokenHandler(w, r)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("Unexpected status code. Expected: %d, Got: %d (%s)", tt.expectedStatus, w.Code, w.Body.String())
+			}
+		})
+	}
+
+	app.wg.Wait()
+}
+
+func TestCreateAuthenticationTokenHandler(t *testing.T) {
+	defer func(previous data.PasswordHasher) { data.PreferredHasher = previous }(data.PreferredHasher)
+	data.PreferredHasher = data.BcryptHasher{Cost: bcrypt.MinCost}
+
+	app := &application{
+		logger:        jsonlog.New(os.Stdout, jsonlog.LevelFatal),
+		models:        data.NewMemoryModels(),
+		loginThrottle: newLoginThrottle(100, time.Minute, 0, 0),
+	}
+	app.config.login.enabled = true
+	app.config.login.maxFailures = 2
+	app.config.login.lockoutDuration = time.Minute
+
+	for _, email := range []string{"alice@example.com", "bob@example.com"} {
+		user := &data.User{Name: "Test", Email: email, Activated: true}
+		if err := user.Password.Set("pa55word1234"); err != nil {
+			t.Fatalf("Failed to set password: %v", err)
+		}
+		if err := app.models.Users.Insert(context.Background(), user); err != nil {
+			t.Fatalf("Failed to insert user: %v", err)
+		}
+	}
+
+	invalid := `"error": "invalid credentials, please confirm and resubmit"`
+
+	tests := []struct {
+		Name           string
+		email          string
+		password       string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{"Unknown email", "nobody@example.com", "pa55word1234", http.StatusUnauthorized, invalid},
+		{"Password too short", "alice@example.com", "short", http.StatusUnprocessableEntity, "at least be 8 characters"},
+		{"Wrong password", "alice@example.com", "wrongpassword", http.StatusUnauthorized, invalid},
+		{"Wrong password that locks the account", "alice@example.com", "wrongpassword", http.StatusUnauthorized, invalid},
+		// a locked account answers exactly like a missing one
+		{"Right password once locked", "alice@example.com", "pa55word1234", http.StatusUnauthorized, invalid},
+		{"Other account", "bob@example.com", "pa55word1234", http.StatusCreated, "authentication_token"},
+	}
+
+	// the cases share one store and run in order, each builds on the state left by the last
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			body := `{"email": "` + tt.email + `", "password": "` + tt.password + `"}`
+			r := httptest.NewRequest(http.MethodPost, "/v1/tokens/authentication", strings.NewReader(body))
+
+			w := httptest.NewRecorder()
+			app.createAuthenticationTokenHandler(w, r)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("Unexpected status code. Expected: %d, Got: %d (%s)", tt.expectedStatus, w.Code, w.Body.String())
+			}
+
+			if !strings.Contains(w.Body.String(), tt.expectedBody) {
+				t.Errorf("Unexpected body. Expected it to contain: %s, Got: %s", tt.expectedBody, w.Body.String())
+			}
+		})
+	}
+
+	app.wg.Wait()
+}
+
+func TestMagicLink(t *testing.T) {
+	app := &application{
+		logger:  jsonlog.New(os.Stdout, jsonlog.LevelFatal),
+		models:  data.NewMemoryModels(),
+		limiter: ratelimit.NewMemory(),
+	}
+	app.config.magicLink.ttl = 15 * time.Minute
+	app.config.magicLink.url = "http://localhost:3000/login/magic"
+	app.config.magicLink.limit = 2
+	app.config.magicLink.window = time.Hour
+
+	user := &data.User{Name: "Alice", Email: "alice@example.com", Activated: true}
+	if err := user.Password.Set("pa55word1234"); err != nil {
+		t.Fatalf("Failed to set password: %v", err)
+	}
+	if err := app.models.Users.Insert(context.Background(), user); err != nil {
+		t.Fatalf("Failed to insert user: %v", err)
+	}
+
+	issue := func(email string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/v1/tokens/magic-link", strings.NewReader(`{"email": "`+email+`"}`))
+
+		w := httptest.NewRecorder()
+		app.createMagicLinkTokenHandler(w, r)
+		return w
+	}
+
+	known := issue("alice@example.com")
+	unknown := issue("nobody@example.com")
+
+	// a missing account gets exactly the answer an existing one does
+	if known.Code != http.StatusAccepted || unknown.Code != known.Code || unknown.Body.String() != known.Body.String() {
+		t.Errorf("Expected matching answers, Got: %d %s and %d %s", known.Code, known.Body.String(), unknown.Code, unknown.Body.String())
+	}
+
+	tokens, err := app.models.Tokens.GetAllForUser(context.Background(), user.ID)
+	if err != nil || len(tokens) != 1 || tokens[0].Scope != data.ScopeLogin {
+		t.Errorf("Expected one sign in token to be issued, Got: %+v (%v)", tokens, err)
+	}
+
+	// the per address limit ignores case, the limit of two is reached by the second request
+	if w := issue("ALICE@example.com"); w.Code != http.StatusAccepted {
+		t.Errorf("Unexpected status code for the second request. Expected: %d, Got: %d", http.StatusAccepted, w.Code)
+	}
+
+	if w := issue("alice@example.com"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("Unexpected status code over the limit. Expected: %d, Got: %d", http.StatusTooManyRequests, w.Code)
+	}
+
+	// the links themselves only go out by email, so the exchanges use ones made directly
+	link, err := app.models.Tokens.New(context.Background(), user.ID, time.Minute, data.ScopeLogin)
+	if err != nil {
+		t.Fatalf("Failed to create token: %v", err)
+	}
+
+	expired, err := app.models.Tokens.New(context.Background(), user.ID, -time.Minute, data.ScopeLogin)
+	if err != nil {
+		t.Fatalf("Failed to create token: %v", err)
+	}
+
+	tests := []struct {
+		Name           string
+		token          string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{"Valid link", link.Plaintext, http.StatusCreated, "authentication_token"},
+		{"Same link again", link.Plaintext, http.StatusUnprocessableEntity, "invalid or expired sign in token"},
+		{"Expired link", expired.Plaintext, http.StatusUnprocessableEntity, "invalid or expired sign in token"},
+	}
+
+	// the cases share one store and run in order, each builds on the state left by the last
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/v1/tokens/magic-link/exchange", strings.NewReader(`{"token": "`+tt.token+`"}`))
+
+			w := httptest.NewRecorder()
+			app.exchangeMagicLinkTokenHandler(w, r)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("Unexpected status code. Expected: %d, Got: %d (%s)", tt.expectedStatus, w.Code, w.Body.String())
+			}
+
+			if !strings.Contains(w.Body.String(), tt.expectedBody) {
+				t.Errorf("Unexpected body. Expected it to contain: %s, Got: %s", tt.expectedBody, w.Body.String())
+			}
+		})
+	}
+
+	app.wg.Wait()
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"errors"
+	"movie_api/internal/data"
+	"movie_api/internal/totp"
+	"movie_api/internal/validator"
+	"net/http"
+	"time"
+)
+
+const totpIssuer = "MovieBuff"
+
+func (app *application) enrolTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	// signed tokens only carry the id, so always work from the stored user
+	user, err := app.models.Users.Get(r.Context(), app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if user.TOTPEnabled {
+		v := validator.New()
+		v.AddError("totp", "two-factor authentication is already enabled")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	user.TOTPSecret = secret
+
+	err = app.models.Users.Update(r.Context(), user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	env := envelope{
+		"secret": secret,
+		"uri":    totp.URI(totpIssuer, user.Email, secret),
+	}
+
+	err = app.writeJson(w, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) confirmTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Code string `json:"code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if v.Check(input.Code != "", "code", "must be provided"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.Get(r.Context(), app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if user.TOTPSecret == "" || user.TOTPEnabled {
+		v.AddError("totp", "no two-factor enrolment is pending")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	step, valid := totp.Validate(user.TOTPSecret, input.Code, time.Now())
+	if valid {
+		// spending the step here means the code used to confirm can't then be replayed to sign in
+		valid, err = app.models.Users.AcceptTOTPStep(r.Context(), user.ID, step)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	if !valid {
+		v.AddError("code", "invalid or expired code")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user.TOTPEnabled = true
+	version := user.Version
+
+	var codes []string
+
+	// 2FA is only switched on together with the recovery codes, an account enforcing it without any is one
+	// lost phone away from being locked out for good
+	err = app.models.WithTx(r.Context(), func(tx data.Models) error {
+		// WithTx may retry, and Update bumps the version on the copy it was given
+		user.Version = version
+
+		err := tx.Users.Update(r.Context(), user)
+		if err != nil {
+			return err
+		}
+
+		codes, err = tx.RecoveryCodes.New(r.Context(), user.ID)
+		return err
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	env := envelope{
+		"user":           user,
+		"recovery_codes": codes,
+	}
+
+	err = app.writeJson(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
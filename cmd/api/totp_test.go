@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"movie_api/internal/data"
+	"movie_api/internal/jsonlog"
+	"movie_api/internal/totp"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTOTPEnrolment(t *testing.T) {
+	app := &application{
+		logger: jsonlog.New(os.Stdout, jsonlog.LevelFatal),
+		models: data.NewMemoryModels(),
+	}
+
+	user := &data.User{Name: "Alice", Email: "alice@example.com", Activated: true}
+	if err := user.Password.Set("pa55word1234"); err != nil {
+		t.Fatalf("Failed to set password: %v", err)
+	}
+	if err := app.models.Users.Insert(context.Background(), user); err != nil {
+		t.Fatalf("Failed to insert user: %v", err)
+	}
+
+	serve := func(handler http.HandlerFunc, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/v1/users/totp", strings.NewReader(body))
+		r = app.contextSetUser(r, user)
+
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w
+	}
+
+	w := serve(app.enrolTOTPHandler, "")
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Unexpected status code enrolling. Expected: %d, Got: %d", http.StatusCreated, w.Code)
+	}
+
+	var enrolment struct {
+		Secret string `json:"secret"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &enrolment); err != nil {
+		t.Fatalf("Failed to decode enrolment: %v", err)
+	}
+
+	if w := serve(app.confirmTOTPHandler, `{"code": "12345"}`); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Unexpected status code for a bad code. Expected: %d, Got: %d", http.StatusUnprocessableEntity, w.Code)
+	}
+
+	code, err := totp.Code(enrolment.Secret, time.Now())
+	if err != nil {
+		t.Fatalf("Failed to generate code: %v", err)
+	}
+
+	w = serve(app.confirmTOTPHandler, `{"code": "`+code+`"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Unexpected status code confirming. Expected: %d, Got: %d (%s)", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	var confirmed struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &confirmed); err != nil || len(confirmed.RecoveryCodes) == 0 {
+		t.Fatalf("Expected recovery codes with the confirmation, Got: %s (%v)", w.Body.String(), err)
+	}
+
+	stored, err := app.models.Users.Get(context.Background(), user.ID)
+	if err != nil || !stored.TOTPEnabled {
+		t.Errorf("Expected 2FA to be enabled, Got: %+v (%v)", stored, err)
+	}
+
+	// the recovery codes were stored with the flag, one of them works
+	ok, err := app.models.RecoveryCodes.Consume(context.Background(), user.ID, confirmed.RecoveryCodes[0])
+	if err != nil || !ok {
+		t.Errorf("Expected a recovery code to be usable, Got: %t (%v)", ok, err)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"movie_api/internal/jsonlog"
+	"movie_api/internal/trace"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+// newTracer builds the tracer for the configured exporter, nil when tracing is off
+func newTracer(cfg config, logger *jsonlog.Logger) (*trace.Tracer, error) {
+	var exporter trace.Exporter
+
+	switch cfg.tracing.exporter {
+	case "none":
+		return nil, nil
+	case "stdout":
+		exporter = trace.NewWriterExporter(os.Stdout)
+	case "file":
+		f, err := os.OpenFile(cfg.tracing.file, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+		if err != nil {
+			return nil, err
+		}
+		exporter = trace.NewWriterExporter(f)
+	case "otlp":
+		exporter = trace.NewOTLPExporter(cfg.tracing.otlpEndpoint, "movie_api")
+	default:
+		return nil, fmt.Errorf("unknown tracing exporter %q", cfg.tracing.exporter)
+	}
+
+	return trace.New(exporter, cfg.tracing.sampleRatio, func(err error) {
+		logger.PrintError(err, map[string]string{"component": "tracing"})
+	}), nil
+}
+
+// traceRequests starts the server span for each request, continuing the caller's trace when a traceparent
+// header comes in. It sits inside metrics so the route pattern the router matched is there to name the span.
+func (app *application) traceRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		if remote, ok := trace.ParseTraceparent(r.Header.Get("traceparent")); ok {
+			ctx = trace.ContextWithRemote(ctx, remote)
+		}
+
+		ctx, span := trace.StartKind(ctx, r.Method, trace.KindServer)
+		defer span.Finish()
+
+		span.SetAttribute("http.method", r.Method)
+		span.SetAttribute("http.target", r.URL.Path)
+		if id := app.contextGetRequestID(r); id != "" {
+			span.SetAttribute("http.request_id", id)
+		}
+
+		mw := newMetricsResponseWriter(w)
+		next.ServeHTTP(mw, r.WithContext(ctx))
+
+		if state, ok := r.Context().Value(requestStateContextKey).(*requestState); ok {
+			span.SetName(r.Method + " " + state.route)
+			span.SetAttribute("http.route", state.route)
+		}
+
+		span.SetAttribute("http.status_code", strconv.Itoa(mw.statusCode))
+	})
+}
+
+// finishBefore ends span as soon as the middleware it times hands over to next, so the span covers that
+// middleware's own work rather than the rest of the request. Deferring Finish as well covers early returns.
+func finishBefore(span *trace.Span, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		span.Finish()
+		next.ServeHTTP(w, r)
+	})
+}
@@ -2,17 +2,21 @@ package main
 
 import (
 	"errors"
+	"fmt"
 	"movie_api/internal/data"
+	"movie_api/internal/totp"
 	"movie_api/internal/validator"
 	"net/http"
+	"strings"
 	"time"
 )
 
 func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Name     string `json:"name"`
-		Email    string `json:"email"`
-		Password string `json:"password"`
+		Name        string `json:"name"`
+		Email       string `json:"email"`
+		Password    string `json:"password"`
+		InviteToken string `json:"invite_token"`
 	}
 
 	err := app.readJSON(w, r, &input)
@@ -21,6 +25,41 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	v := validator.New()
+
+	if app.config.registration.inviteOnly {
+		v.Check(input.InviteToken != "", "invite_token", "must be provided")
+	}
+
+	var invitation *data.Invitation
+
+	if input.InviteToken != "" {
+		if data.ValidateTokenPlainText(v, input.InviteToken); !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		invitation, err = app.models.Tokens.GetInvitation(r.Context(), input.InviteToken)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				v.AddError("invite_token", "invalid or expired invitation")
+				app.failedValidationResponse(w, r, v.Errors)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		// invitations can't be passed on to someone else
+		v.Check(strings.EqualFold(invitation.Email, input.Email), "invite_token", "was not issued for this email address")
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
 	// activated at first is false, there will be plans for user to register their account
 	user := &data.User{
 		Name:      input.Name,
@@ -34,38 +73,59 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	v := validator.New()
-
 	if data.ValidateUser(v, user); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	err = app.models.Users.Insert(user)
+	var token *data.Token
+
+	// the user, their permissions and activation token are created together, a failure part way through
+	// mustn't leave an account behind that can't do anything
+	err = app.models.WithTx(r.Context(), func(tx data.Models) error {
+		err := tx.Users.Insert(r.Context(), user)
+		if err != nil {
+			return err
+		}
+
+		// all users should have access to read the movie data. Initiate upon registration
+		err = tx.Permissions.AddForUser(r.Context(), user.ID, "movies:read")
+		if err != nil {
+			return err
+		}
+
+		if invitation != nil {
+			if len(invitation.Permissions) > 0 {
+				err = tx.Permissions.AddForUser(r.Context(), user.ID, invitation.Permissions...)
+				if err != nil {
+					return err
+				}
+			}
+
+			// consuming the invitation is what claims it, two registrations racing with one can't both win
+			_, err = tx.Tokens.Consume(r.Context(), data.ScopeInvite, input.InviteToken)
+			if err != nil {
+				return err
+			}
+		}
+
+		token, err = tx.Tokens.New(r.Context(), user.ID, 3*24*time.Hour, data.ScopeActivation)
+		return err
+	})
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrDuplicateEmail):
 			v.AddError("email", "a user with this email address already exists")
 			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("invite_token", "invalid or expired invitation")
+			app.failedValidationResponse(w, r, v.Errors)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
 		return
 	}
 
-	// all users should have access to read the movie data. Initiate upon registration
-	err = app.models.Permissions.AddForUser(user.ID, "movies:read")
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
-		return
-	}
-
-	token, err := app.models.Tokens.New(user.ID, 3*24*time.Hour, data.ScopeActivation)
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
-		return
-	}
-
 	// creates a background go routine of the function passed as the arg, also catches any panics within the
 	// background process
 	app.background(func() {
@@ -73,7 +133,7 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 			"activationToken": token.Plaintext,
 		}
 
-		err = app.mailer.Send(user.Email, "user_welcome.tmpl", data)
+		err = app.mailer.Send(r.Context(), user.Email, "user_welcome.tmpl", data)
 		if err != nil {
 			app.logger.PrintError(err, nil)
 		}
@@ -103,7 +163,7 @@ func (app *application) createActivationTokenHandler(w http.ResponseWriter, r *h
 		return
 	}
 
-	user, err := app.models.Users.GetByEmail(input.Email)
+	user, err := app.models.Users.GetByEmail(r.Context(), input.Email)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -121,7 +181,7 @@ func (app *application) createActivationTokenHandler(w http.ResponseWriter, r *h
 		return
 	}
 
-	token, err := app.models.Tokens.New(user.ID, 3*24*time.Hour, data.ScopeActivation)
+	token, err := app.models.Tokens.New(r.Context(), user.ID, 3*24*time.Hour, data.ScopeActivation)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -132,7 +192,7 @@ func (app *application) createActivationTokenHandler(w http.ResponseWriter, r *h
 			"activationToken": token.Plaintext,
 		}
 
-		err = app.mailer.Send(user.Email, "token_activation.tmpl", data)
+		err = app.mailer.Send(r.Context(), user.Email, "token_activation.tmpl", data)
 		if err != nil {
 			app.logger.PrintError(err, nil)
 		}
@@ -165,7 +225,7 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	user, err := app.models.Users.GetForToken(data.ScopeActivation, input.TokenPlaintext)
+	user, err := app.models.Users.GetForToken(r.Context(), data.ScopeActivation, input.TokenPlaintext)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -179,7 +239,7 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 
 	user.Activated = true
 
-	err = app.models.Users.Update(user)
+	err = app.models.Users.Update(r.Context(), user)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrEditConflict):
@@ -190,7 +250,7 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	err = app.models.Tokens.DeleteAllForUser(data.ScopeActivation, user.ID)
+	err = app.models.Tokens.DeleteAllForUser(r.Context(), data.ScopeActivation, user.ID)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -203,6 +263,56 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 	}
 }
 
+func (app *application) unlockUserHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateTokenPlainText(v, input.TokenPlaintext); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(r.Context(), data.ScopeUnlock, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired unlock token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.Lockouts.Reset(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Tokens.DeleteAllForUser(r.Context(), data.ScopeUnlock, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{"message": "your account has been unlocked"}
+
+	err = app.writeJson(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
 func (app *application) updatePasswordHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
 		Password       string `json:"password"`
@@ -225,7 +335,7 @@ func (app *application) updatePasswordHandler(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	user, err := app.models.Users.GetForToken(data.ScopePasswordReset, input.TokenPlaintext)
+	user, err := app.models.Users.GetForToken(r.Context(), data.ScopePasswordReset, input.TokenPlaintext)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -237,13 +347,18 @@ func (app *application) updatePasswordHandler(w http.ResponseWriter, r *http.Req
 		return
 	}
 
+	if data.ValidatePasswordStrength(v, input.Password, user.Name, user.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
 	err = user.Password.Set(input.Password)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	err = app.models.Users.Update(user)
+	err = app.models.Users.Update(r.Context(), user)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrEditConflict):
@@ -254,7 +369,7 @@ func (app *application) updatePasswordHandler(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	err = app.models.Tokens.DeleteAllForUser(data.ScopePasswordReset, user.ID)
+	err = app.models.Tokens.DeleteAllForUser(r.Context(), data.ScopePasswordReset, user.ID)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -267,3 +382,515 @@ func (app *application) updatePasswordHandler(w http.ResponseWriter, r *http.Req
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+func (app *application) requestEmailChangeHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	data.ValidateEmail(v, input.Email)
+	data.ValidatePasswordPlaintext(v, input.Password)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.Get(r.Context(), app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	match, err := user.Password.Matches(input.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !match {
+		app.invalidCredentialResponse(w, r)
+		return
+	}
+
+	if strings.EqualFold(input.Email, user.Email) {
+		v.AddError("email", "must be different to your current email address")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// this is only a courtesy check, the address could still be taken before the change is confirmed
+	_, err = app.models.Users.GetByEmail(r.Context(), input.Email)
+	switch {
+	case err == nil:
+		v.AddError("email", "a user with this email address already exists")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	case !errors.Is(err, data.ErrRecordNotFound):
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// only the latest requested address can be confirmed
+	err = app.models.Tokens.DeleteAllForUser(r.Context(), data.ScopeEmailChange, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	token, err := app.models.Tokens.NewWithPayload(r.Context(), user.ID, 24*time.Hour, data.ScopeEmailChange, input.Email)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.background(func() {
+		err := app.mailer.Send(r.Context(), input.Email, "email_change.tmpl", map[string]any{
+			"emailChangeToken": token.Plaintext,
+		})
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+
+		err = app.mailer.Send(r.Context(), user.Email, "email_change_notice.tmpl", map[string]any{
+			"newEmail": input.Email,
+		})
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	})
+
+	env := envelope{"message": "an email will be sent to your new address containing confirmation instructions"}
+
+	err = app.writeJson(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) confirmEmailChangeHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateTokenPlainText(v, input.TokenPlaintext); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	var user *data.User
+
+	// consuming the token, which also gives the address it was for, and changing the email commit together. Two
+	// confirmations racing with one token can't both win, and a failed change leaves the token usable.
+	err = app.models.WithTx(r.Context(), func(tx data.Models) error {
+		userID, newEmail, err := tx.Tokens.ConsumeWithPayload(r.Context(), data.ScopeEmailChange, input.TokenPlaintext)
+		if err != nil {
+			return err
+		}
+
+		user, err = tx.Users.Get(r.Context(), userID)
+		if err != nil {
+			return err
+		}
+
+		user.Email = newEmail
+
+		err = tx.Users.Update(r.Context(), user)
+		if err != nil {
+			return err
+		}
+
+		return tx.Tokens.DeleteAllForUser(r.Context(), data.ScopeEmailChange, user.ID)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired email change token")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrDuplicateEmail):
+			v.AddError("email", "a user with this email address already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJson(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := app.models.Users.Get(r.Context(), app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJson(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := app.models.Users.Get(r.Context(), app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// email and password have their own endpoints as they need confirming
+	var input struct {
+		Name *string `json:"name"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Name != nil {
+		user.Name = *input.Name
+	}
+
+	v := validator.New()
+
+	if data.ValidateUser(v, user); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Users.Update(r.Context(), user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJson(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) changePasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(input.CurrentPassword != "", "current_password", "must be provided")
+	data.ValidatePasswordPlaintext(v, input.NewPassword)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.Get(r.Context(), app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	match, err := user.Password.Matches(input.CurrentPassword)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !match {
+		app.invalidCredentialResponse(w, r)
+		return
+	}
+
+	if data.ValidatePasswordStrength(v, input.NewPassword, user.Name, user.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = user.Password.Set(input.NewPassword)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	currentToken := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	version := user.Version
+
+	// everything issued before the change is ended with it, apart from the session making it. Stored tokens of
+	// every scope are deleted, and moving the token generation on revokes every signed token, this one included.
+	err = app.models.WithTx(r.Context(), func(tx data.Models) error {
+		user.Version = version
+
+		err := tx.Users.Update(r.Context(), user)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Users.BumpTokenGeneration(r.Context(), user.ID)
+		if err != nil {
+			return err
+		}
+
+		return tx.Tokens.DeleteAllForUserExcept(r.Context(), user.ID, currentToken)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	env := envelope{"message": "your password was successfully changed"}
+
+	err = app.writeJson(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) exportCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := app.models.Users.Get(r.Context(), app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	tokens, err := app.models.Tokens.GetAllForUser(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// token hashes are never exported, only what they're for and when they expire
+	tokenMetadata := make([]map[string]any, 0, len(tokens))
+	for _, token := range tokens {
+		tokenMetadata = append(tokenMetadata, map[string]any{
+			"scope":     token.Scope,
+			"expiry":    token.Expiry,
+			"client_ip": token.ClientIP,
+		})
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	movies, err := app.models.Movies.GetAllForCreator(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{
+		"export": map[string]any{
+			"generated_at": time.Now().UTC(),
+			"user":         user,
+			"tokens":       tokenMetadata,
+			"permissions":  permissions,
+			"movies":       movies,
+		},
+	}
+
+	headers := make(http.Header)
+	headers.Set("Content-Disposition", fmt.Sprintf(`attachment; filename="user-%d-export.json"`, user.ID))
+
+	err = app.writeJson(w, http.StatusOK, env, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Password string `json:"password"`
+		Code     string `json:"code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if v.Check(input.Password != "", "password", "must be provided"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.Get(r.Context(), app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if v.Check(!user.TOTPEnabled || input.Code != "", "code", "must be provided when two-factor authentication is enabled"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	match, err := user.Password.Matches(input.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// the second factor is checked just like at login, an authenticator code or a recovery code
+	if match && user.TOTPEnabled {
+		if step, valid := totp.Validate(user.TOTPSecret, input.Code, time.Now()); valid {
+			match, err = app.models.Users.AcceptTOTPStep(r.Context(), user.ID, step)
+		} else {
+			match, err = app.models.RecoveryCodes.Consume(r.Context(), user.ID, input.Code)
+		}
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	if !match {
+		app.invalidCredentialResponse(w, r)
+		return
+	}
+
+	var (
+		deletion *data.AccountDeletion
+		token    *data.Token
+	)
+
+	// sign the account out everywhere straight away, the data itself goes once the grace period is up. The one
+	// token left is the one mailed out to cancel the deletion, which lasts as long as the account does.
+	err = app.models.WithTx(r.Context(), func(tx data.Models) error {
+		deletion, err = tx.Deletions.Schedule(r.Context(), user.ID, app.config.deletion.gracePeriod)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Tokens.DeleteAllForUserAllScopes(r.Context(), user.ID)
+		if err != nil {
+			return err
+		}
+
+		token, err = tx.Tokens.New(r.Context(), user.ID, time.Until(deletion.DeleteAfter), data.ScopeDeletionCancel)
+		return err
+	})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.background(func() {
+		err := app.mailer.Send(r.Context(), user.Email, "account_deletion.tmpl", map[string]any{
+			"deletionCancelToken": token.Plaintext,
+			"deleteAfter":         deletion.DeleteAfter.UTC().Format(time.RFC1123),
+		})
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	})
+
+	env := envelope{
+		"message":  "your account has been scheduled for deletion, an email will be sent with instructions to cancel it",
+		"deletion": deletion,
+	}
+
+	err = app.writeJson(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// cancelDeletionHandler takes an account off the deletion schedule with the token mailed out when it was put on,
+// it can't take an authentication token since scheduling the deletion signed the account out everywhere
+func (app *application) cancelDeletionHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateTokenPlainText(v, input.TokenPlaintext); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.WithTx(r.Context(), func(tx data.Models) error {
+		userID, err := tx.Tokens.Consume(r.Context(), data.ScopeDeletionCancel, input.TokenPlaintext)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Deletions.Cancel(r.Context(), userID)
+		if err != nil {
+			return err
+		}
+
+		return tx.Tokens.DeleteAllForUser(r.Context(), data.ScopeDeletionCancel, userID)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired deletion cancellation token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	env := envelope{"message": "your account is no longer scheduled for deletion, you can sign in again"}
+
+	err = app.writeJson(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
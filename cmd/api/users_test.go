@@ -0,0 +1,480 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"golang.org/x/crypto/bcrypt"
+	"movie_api/internal/data"
+	"movie_api/internal/jsonlog"
+	"movie_api/internal/jwt"
+	"movie_api/internal/totp"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEmailChange(t *testing.T) {
+	defer func(previous data.PasswordHasher) { data.PreferredHasher = previous }(data.PreferredHasher)
+	data.PreferredHasher = data.BcryptHasher{Cost: bcrypt.MinCost}
+
+	app := &application{
+		logger: jsonlog.New(os.Stdout, jsonlog.LevelFatal),
+		models: data.NewMemoryModels(),
+	}
+
+	var users []*data.User
+	for _, email := range []string{"alice@example.com", "bob@example.com"} {
+		user := &data.User{Name: "Test", Email: email, Activated: true}
+		if err := user.Password.Set("pa55word1234"); err != nil {
+			t.Fatalf("Failed to set password: %v", err)
+		}
+		if err := app.models.Users.Insert(context.Background(), user); err != nil {
+			t.Fatalf("Failed to insert user: %v", err)
+		}
+		users = append(users, user)
+	}
+	alice, bob := users[0], users[1]
+
+	requests := []struct {
+		Name           string
+		email          string
+		password       string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{"Wrong password", "alice@example.org", "wrongpassword", http.StatusUnauthorized, "invalid credentials"},
+		{"Same address", "alice@example.com", "pa55word1234", http.StatusUnprocessableEntity, "must be different"},
+		{"Address of another user", "bob@example.com", "pa55word1234", http.StatusUnprocessableEntity, "already exists"},
+		{"New address", "alice@example.org", "pa55word1234", http.StatusAccepted, "confirmation instructions"},
+	}
+
+	// the cases share one store and run in order, each builds on the state left by the last
+	for _, tt := range requests {
+		t.Run(tt.Name, func(t *testing.T) {
+			body := `{"email": "` + tt.email + `", "password": "` + tt.password + `"}`
+			r := httptest.NewRequest(http.MethodPut, "/v1/users/me/email", strings.NewReader(body))
+			r = app.contextSetUser(r, alice)
+
+			w := httptest.NewRecorder()
+			app.requestEmailChangeHandler(w, r)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("Unexpected status code. Expected: %d, Got: %d (%s)", tt.expectedStatus, w.Code, w.Body.String())
+			}
+
+			if !strings.Contains(w.Body.String(), tt.expectedBody) {
+				t.Errorf("Unexpected body. Expected it to contain: %s, Got: %s", tt.expectedBody, w.Body.String())
+			}
+		})
+	}
+
+	// the token itself only goes out by email, so the confirmations use ones made directly
+	newToken := func(user *data.User, email string) string {
+		token, err := app.models.Tokens.NewWithPayload(context.Background(), user.ID, time.Hour, data.ScopeEmailChange, email)
+		if err != nil {
+			t.Fatalf("Failed to create token: %v", err)
+		}
+		return token.Plaintext
+	}
+
+	aliceToken := newToken(alice, "alice@example.org")
+	// bob asks for an address that alice takes before bob confirms it
+	bobToken := newToken(bob, "alice@example.org")
+
+	confirmations := []struct {
+		Name           string
+		token          string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{"Unknown token", "ABCDEFGHIJKLMNOPQRSTUVWXYZ", http.StatusUnprocessableEntity, "invalid or expired"},
+		{"Valid token", aliceToken, http.StatusOK, "alice@example.org"},
+		{"Same token again", aliceToken, http.StatusUnprocessableEntity, "invalid or expired"},
+		{"Address taken since the request", bobToken, http.StatusUnprocessableEntity, "already exists"},
+	}
+
+	for _, tt := range confirmations {
+		t.Run(tt.Name, func(t *testing.T) {
+			body := `{"token": "` + tt.token + `"}`
+			r := httptest.NewRequest(http.MethodPut, "/v1/users/me/email/confirmed", strings.NewReader(body))
+
+			w := httptest.NewRecorder()
+			app.confirmEmailChangeHandler(w, r)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("Unexpected status code. Expected: %d, Got: %d (%s)", tt.expectedStatus, w.Code, w.Body.String())
+			}
+
+			if !strings.Contains(w.Body.String(), tt.expectedBody) {
+				t.Errorf("Unexpected body. Expected it to contain: %s, Got: %s", tt.expectedBody, w.Body.String())
+			}
+		})
+	}
+
+	stored, err := app.models.Users.Get(context.Background(), bob.ID)
+	if err != nil || stored.Email != "bob@example.com" {
+		t.Errorf("Expected bob to keep the old address, Got: %+v (%v)", stored, err)
+	}
+
+	// the failed change rolled back, so bob's token wasn't spent on it
+	if _, _, err := app.models.Tokens.ConsumeWithPayload(context.Background(), data.ScopeEmailChange, bobToken); err != nil {
+		t.Errorf("Expected the token of a failed change to stay usable, Got: %v", err)
+	}
+
+	app.wg.Wait()
+}
+
+func TestChangePassword(t *testing.T) {
+	defer func(previous data.PasswordHasher) { data.PreferredHasher = previous }(data.PreferredHasher)
+	data.PreferredHasher = data.BcryptHasher{Cost: bcrypt.MinCost}
+
+	signer, err := jwt.New("test", "movie_api", map[string][]byte{"test": []byte(strings.Repeat("k", 32))})
+	if err != nil {
+		t.Fatalf("Failed to create signer: %v", err)
+	}
+
+	app := &application{
+		logger: jsonlog.New(os.Stdout, jsonlog.LevelFatal),
+		models: data.NewMemoryModels(),
+		signer: signer,
+	}
+	app.config.auth.tokenMode = "jwt"
+	app.config.auth.jwt.ttl = time.Hour
+
+	user := &data.User{Name: "Alice", Email: "alice@example.com", Activated: true}
+	if err := user.Password.Set("pa55word1234"); err != nil {
+		t.Fatalf("Failed to set password: %v", err)
+	}
+	if err := app.models.Users.Insert(context.Background(), user); err != nil {
+		t.Fatalf("Failed to insert user: %v", err)
+	}
+
+	current, err := app.models.Tokens.NewSession(context.Background(), user.ID, time.Hour, "203.0.113.7")
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+
+	for _, scope := range []string{data.ScopeAuthentication, data.ScopePasswordReset, data.ScopeMFA} {
+		if _, err := app.models.Tokens.New(context.Background(), user.ID, time.Hour, scope); err != nil {
+			t.Fatalf("Failed to create %s token: %v", scope, err)
+		}
+	}
+
+	signed, err := app.newSignedToken(httptest.NewRequest(http.MethodPost, "/v1/tokens/authentication", nil), user)
+	if err != nil {
+		t.Fatalf("Failed to sign token: %v", err)
+	}
+
+	authenticated := func(token string) int {
+		r := httptest.NewRequest(http.MethodGet, "/v1/users/me", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+
+		w := httptest.NewRecorder()
+		app.authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(w, r)
+		return w.Code
+	}
+
+	if code := authenticated(signed.Plaintext); code != http.StatusOK {
+		t.Fatalf("Unexpected status code for the signed token before the change. Expected: %d, Got: %d", http.StatusOK, code)
+	}
+
+	body := `{"current_password": "pa55word1234", "new_password": "correct horse battery staple"}`
+	r := httptest.NewRequest(http.MethodPut, "/v1/users/me/password", strings.NewReader(body))
+	r.Header.Set("Authorization", "Bearer "+current.Plaintext)
+	r = app.contextSetUser(r, user)
+
+	w := httptest.NewRecorder()
+	app.changePasswordHandler(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Unexpected status code. Expected: %d, Got: %d (%s)", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	// every stored token of every scope is gone apart from the session that made the change
+	tokens, err := app.models.Tokens.GetAllForUser(context.Background(), user.ID)
+	if err != nil || len(tokens) != 1 || tokens[0].ClientIP != "203.0.113.7" {
+		t.Errorf("Expected only the current session to be left, Got: %+v (%v)", tokens, err)
+	}
+
+	if code := authenticated(current.Plaintext); code != http.StatusOK {
+		t.Errorf("Unexpected status code for the current session. Expected: %d, Got: %d", http.StatusOK, code)
+	}
+
+	if code := authenticated(signed.Plaintext); code != http.StatusUnauthorized {
+		t.Errorf("Unexpected status code for the signed token after the change. Expected: %d, Got: %d", http.StatusUnauthorized, code)
+	}
+}
+
+func TestAccountDeletion(t *testing.T) {
+	defer func(previous data.PasswordHasher) { data.PreferredHasher = previous }(data.PreferredHasher)
+	data.PreferredHasher = data.BcryptHasher{Cost: bcrypt.MinCost}
+
+	signer, err := jwt.New("test", "movie_api", map[string][]byte{"test": []byte(strings.Repeat("k", 32))})
+	if err != nil {
+		t.Fatalf("Failed to create signer: %v", err)
+	}
+
+	app := &application{
+		logger: jsonlog.New(os.Stdout, jsonlog.LevelFatal),
+		models: data.NewMemoryModels(),
+		signer: signer,
+	}
+	app.config.auth.tokenMode = "jwt"
+	app.config.auth.jwt.ttl = time.Hour
+	app.config.deletion.gracePeriod = 24 * time.Hour
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		t.Fatalf("Failed to generate secret: %v", err)
+	}
+
+	user := &data.User{Name: "Alice", Email: "alice@example.com", Activated: true, TOTPSecret: secret, TOTPEnabled: true}
+	if err := user.Password.Set("pa55word1234"); err != nil {
+		t.Fatalf("Failed to set password: %v", err)
+	}
+	if err := app.models.Users.Insert(context.Background(), user); err != nil {
+		t.Fatalf("Failed to insert user: %v", err)
+	}
+
+	session, err := app.models.Tokens.NewSession(context.Background(), user.ID, time.Hour, "203.0.113.7")
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+
+	signed, err := app.newSignedToken(httptest.NewRequest(http.MethodPost, "/v1/tokens/authentication", nil), user)
+	if err != nil {
+		t.Fatalf("Failed to sign token: %v", err)
+	}
+
+	authenticated := func(token string) int {
+		r := httptest.NewRequest(http.MethodGet, "/v1/users/me", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+
+		w := httptest.NewRecorder()
+		app.authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(w, r)
+		return w.Code
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/v1/users/me/export", nil)
+	r = app.contextSetUser(r, user)
+
+	w := httptest.NewRecorder()
+	app.exportCurrentUserHandler(w, r)
+
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"client_ip": "203.0.113.7"`) {
+		t.Errorf("Unexpected export. Expected a 200 listing the session, Got: %d (%s)", w.Code, w.Body.String())
+	}
+
+	if disposition := w.Header().Get("Content-Disposition"); !strings.Contains(disposition, "attachment") {
+		t.Errorf("Expected the export to be an attachment, Got: %q", disposition)
+	}
+
+	code, err := totp.Code(secret, time.Now())
+	if err != nil {
+		t.Fatalf("Failed to generate code: %v", err)
+	}
+
+	wrong := "000000"
+	if code == wrong {
+		wrong = "111111"
+	}
+
+	deletes := []struct {
+		Name           string
+		body           string
+		expectedStatus int
+	}{
+		{"Without a code", `{"password": "pa55word1234"}`, http.StatusUnprocessableEntity},
+		{"Wrong password", `{"password": "wrongpassword", "code": "` + code + `"}`, http.StatusUnauthorized},
+		{"Wrong code", `{"password": "pa55word1234", "code": "` + wrong + `"}`, http.StatusUnauthorized},
+		{"Password and code", `{"password": "pa55word1234", "code": "` + code + `"}`, http.StatusAccepted},
+	}
+
+	// the cases share one store and run in order, each builds on the state left by the last
+	for _, tt := range deletes {
+		t.Run(tt.Name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodDelete, "/v1/users/me", strings.NewReader(tt.body))
+			r = app.contextSetUser(r, user)
+
+			w := httptest.NewRecorder()
+			app.deleteCurrentUserHandler(w, r)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("Unexpected status code. Expected: %d, Got: %d (%s)", tt.expectedStatus, w.Code, w.Body.String())
+			}
+		})
+	}
+
+	// the session was deleted, and the signed token is turned away while the deletion is pending
+	if code := authenticated(session.Plaintext); code != http.StatusUnauthorized {
+		t.Errorf("Unexpected status code for the session. Expected: %d, Got: %d", http.StatusUnauthorized, code)
+	}
+
+	if code := authenticated(signed.Plaintext); code != http.StatusUnauthorized {
+		t.Errorf("Unexpected status code for the signed token. Expected: %d, Got: %d", http.StatusUnauthorized, code)
+	}
+
+	// the only token left is the one mailed out to cancel the deletion
+	tokens, err := app.models.Tokens.GetAllForUser(context.Background(), user.ID)
+	if err != nil || len(tokens) != 1 || tokens[0].Scope != data.ScopeDeletionCancel {
+		t.Fatalf("Expected a single cancellation token, Got: %+v (%v)", tokens, err)
+	}
+
+	// which only goes out by email, so the cancellation uses one made directly
+	cancel, err := app.models.Tokens.New(context.Background(), user.ID, time.Hour, data.ScopeDeletionCancel)
+	if err != nil {
+		t.Fatalf("Failed to create token: %v", err)
+	}
+
+	cancels := []struct {
+		Name           string
+		token          string
+		expectedStatus int
+	}{
+		{"Unknown token", "ABCDEFGHIJKLMNOPQRSTUVWXYZ", http.StatusUnprocessableEntity},
+		{"Valid token", cancel.Plaintext, http.StatusOK},
+		{"Same token again", cancel.Plaintext, http.StatusUnprocessableEntity},
+	}
+
+	for _, tt := range cancels {
+		t.Run(tt.Name, func(t *testing.T) {
+			body := `{"token": "` + tt.token + `"}`
+			r := httptest.NewRequest(http.MethodPut, "/v1/users/restored", strings.NewReader(body))
+
+			w := httptest.NewRecorder()
+			app.cancelDeletionHandler(w, r)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("Unexpected status code. Expected: %d, Got: %d (%s)", tt.expectedStatus, w.Code, w.Body.String())
+			}
+		})
+	}
+
+	if _, err := app.models.Deletions.Get(context.Background(), user.ID); !errors.Is(err, data.ErrRecordNotFound) {
+		t.Errorf("Expected the deletion to be cancelled, Got: %v", err)
+	}
+
+	if tokens, _ := app.models.Tokens.GetAllForUser(context.Background(), user.ID); len(tokens) != 0 {
+		t.Errorf("Expected the cancellation tokens to be cleared, Got: %+v", tokens)
+	}
+
+	if code := authenticated(signed.Plaintext); code != http.StatusOK {
+		t.Errorf("Unexpected status code for the signed token once restored. Expected: %d, Got: %d", http.StatusOK, code)
+	}
+
+	app.wg.Wait()
+}
+
+func TestInvitations(t *testing.T) {
+	defer func(previous data.PasswordHasher) { data.PreferredHasher = previous }(data.PreferredHasher)
+	data.PreferredHasher = data.BcryptHasher{Cost: bcrypt.MinCost}
+
+	app := &application{
+		logger: jsonlog.New(os.Stdout, jsonlog.LevelFatal),
+		models: data.NewMemoryModels(),
+	}
+	app.config.registration.inviteOnly = true
+
+	admin := &data.User{Name: "Admin", Email: "admin@example.com", Activated: true}
+	if err := admin.Password.Set("pa55word1234"); err != nil {
+		t.Fatalf("Failed to set password: %v", err)
+	}
+	if err := app.models.Users.Insert(context.Background(), admin); err != nil {
+		t.Fatalf("Failed to insert user: %v", err)
+	}
+	if err := app.models.Permissions.AddForUser(context.Background(), admin.ID, "movies:read", "movies:write", "users:invite"); err != nil {
+		t.Fatalf("Failed to add permissions: %v", err)
+	}
+
+	invites := []struct {
+		Name           string
+		body           string
+		expectedStatus int
+	}{
+		{"Permission the admin lacks", `{"email": "bob@example.com", "permissions": ["debug:read"]}`, http.StatusUnprocessableEntity},
+		{"Existing user", `{"email": "admin@example.com"}`, http.StatusUnprocessableEntity},
+		{"New user", `{"email": "bob@example.com", "permissions": ["movies:write"]}`, http.StatusAccepted},
+	}
+
+	// the cases share one store and run in order, each builds on the state left by the last
+	for _, tt := range invites {
+		t.Run(tt.Name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/v1/tokens/invite", strings.NewReader(tt.body))
+			r = app.contextSetUser(r, admin)
+
+			w := httptest.NewRecorder()
+			app.createInviteTokenHandler(w, r)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("Unexpected status code. Expected: %d, Got: %d (%s)", tt.expectedStatus, w.Code, w.Body.String())
+			}
+		})
+	}
+
+	tokens, err := app.models.Tokens.GetAllForUser(context.Background(), admin.ID)
+	if err != nil || len(tokens) != 1 || tokens[0].Scope != data.ScopeInvite {
+		t.Errorf("Expected one invitation to be issued, Got: %+v (%v)", tokens, err)
+	}
+
+	// the invitation itself only goes out by email, so registrations use ones made directly
+	newInvite := func(email string, ttl time.Duration) string {
+		token, err := app.models.Tokens.NewInvitation(context.Background(), admin.ID, ttl, &data.Invitation{
+			Email:       email,
+			Permissions: []string{"movies:write"},
+		})
+		if err != nil {
+			t.Fatalf("Failed to create invitation: %v", err)
+		}
+		return token.Plaintext
+	}
+
+	invite := newInvite("carol@example.com", time.Hour)
+	expired := newInvite("dave@example.com", -time.Hour)
+
+	registrations := []struct {
+		Name           string
+		email          string
+		invite         string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{"Without an invitation", "carol@example.com", "", http.StatusUnprocessableEntity, "must be provided"},
+		{"Invitation for someone else", "erin@example.com", invite, http.StatusUnprocessableEntity, "was not issued for this email address"},
+		{"Valid invitation", "carol@example.com", invite, http.StatusCreated, "carol@example.com"},
+		{"Reused invitation", "carol@example.com", invite, http.StatusUnprocessableEntity, "invalid or expired invitation"},
+		{"Expired invitation", "dave@example.com", expired, http.StatusUnprocessableEntity, "invalid or expired invitation"},
+	}
+
+	for _, tt := range registrations {
+		t.Run(tt.Name, func(t *testing.T) {
+			body := `{"name": "Test", "email": "` + tt.email + `", "password": "correct horse battery staple", "invite_token": "` + tt.invite + `"}`
+			r := httptest.NewRequest(http.MethodPost, "/v1/users", strings.NewReader(body))
+
+			w := httptest.NewRecorder()
+			app.registerUserHandler(w, r)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("Unexpected status code. Expected: %d, Got: %d (%s)", tt.expectedStatus, w.Code, w.Body.String())
+			}
+
+			if !strings.Contains(w.Body.String(), tt.expectedBody) {
+				t.Errorf("Unexpected body. Expected it to contain: %s, Got: %s", tt.expectedBody, w.Body.String())
+			}
+		})
+	}
+
+	carol, err := app.models.Users.GetByEmail(context.Background(), "carol@example.com")
+	if err != nil {
+		t.Fatalf("Failed to get invited user: %v", err)
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(context.Background(), carol.ID)
+	if err != nil || !permissions.Include("movies:read") || !permissions.Include("movies:write") {
+		t.Errorf("Expected the invitation's permissions to be granted, Got: %v (%v)", permissions, err)
+	}
+
+	app.wg.Wait()
+}
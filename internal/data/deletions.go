@@ -0,0 +1,127 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+type AccountDeletion struct {
+	UserID      int64     `json:"-"`
+	RequestedAt time.Time `json:"requested_at"`
+	DeleteAfter time.Time `json:"delete_after"`
+}
+
+type DeletionModel struct {
+	DB DBTX
+}
+
+// Schedule marks the account for hard deletion once the grace period has passed, asking twice keeps the first date
+func (m DeletionModel) Schedule(ctx context.Context, userID int64, grace time.Duration) (*AccountDeletion, error) {
+	query := `
+		INSERT INTO account_deletions (user_id, delete_after)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET user_id = EXCLUDED.user_id
+		RETURNING requested_at, delete_after`
+
+	deletion := AccountDeletion{UserID: userID}
+
+	ctx, done := startQuery(ctx, "DeletionModel.Schedule")
+	defer done()
+
+	err := m.DB.QueryRowContext(ctx, query, userID, time.Now().Add(grace)).Scan(&deletion.RequestedAt, &deletion.DeleteAfter)
+	if err != nil {
+		return nil, err
+	}
+
+	return &deletion, nil
+}
+
+func (m DeletionModel) Get(ctx context.Context, userID int64) (*AccountDeletion, error) {
+	query := `
+		SELECT user_id, requested_at, delete_after
+		FROM account_deletions
+		WHERE user_id = $1`
+
+	var deletion AccountDeletion
+
+	ctx, done := startQuery(ctx, "DeletionModel.Get")
+	defer done()
+
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(&deletion.UserID, &deletion.RequestedAt, &deletion.DeleteAfter)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &deletion, nil
+}
+
+// Cancel takes an account off the deletion schedule, it is ErrRecordNotFound when none was scheduled
+func (m DeletionModel) Cancel(ctx context.Context, userID int64) error {
+	query := `
+		DELETE FROM account_deletions
+		WHERE user_id = $1`
+
+	ctx, done := startQuery(ctx, "DeletionModel.Cancel")
+	defer done()
+
+	result, err := m.DB.ExecContext(ctx, query, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// PurgeDue hard deletes every account whose grace period has ended. Authored movies are kept but
+// anonymised first, rather than relying on the foreign key to tidy up after us.
+func (m DeletionModel) PurgeDue(ctx context.Context) (int, error) {
+	ctx, done := startQuery(ctx, "DeletionModel.PurgeDue")
+	defer done()
+
+	var purged int64
+
+	err := inTx(ctx, m.DB, func(tx DBTX) error {
+		query := `
+			UPDATE movies
+			SET created_by = NULL
+			WHERE created_by IN (SELECT user_id FROM account_deletions WHERE delete_after <= NOW())`
+
+		_, err := tx.ExecContext(ctx, query)
+		if err != nil {
+			return err
+		}
+
+		query = `
+			DELETE FROM users
+			WHERE id IN (SELECT user_id FROM account_deletions WHERE delete_after <= NOW())`
+
+		result, err := tx.ExecContext(ctx, query)
+		if err != nil {
+			return err
+		}
+
+		purged, err = result.RowsAffected()
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return int(purged), nil
+}
@@ -0,0 +1,173 @@
+package data
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var ErrUnknownHashFormat = errors.New("unknown password hash format")
+
+// PasswordHasher produces self-describing hashes, the algorithm and its parameters are stored alongside the
+// hash itself so any registered hasher can check a password without knowing how it was configured at the time.
+type PasswordHasher interface {
+	Hash(plaintext string) ([]byte, error)
+	Matches(hash []byte, plaintext string) (bool, error)
+	// Handles reports whether the hash was produced by this algorithm
+	Handles(hash []byte) bool
+	// Outdated reports whether the hash was produced with weaker parameters than the hasher is set up with
+	Outdated(hash []byte) bool
+}
+
+var (
+	// PreferredHasher is used for every new hash, anything hashed differently is upgraded on the next login
+	PreferredHasher PasswordHasher = BcryptHasher{Cost: 12}
+
+	knownHashers = []PasswordHasher{BcryptHasher{}, Argon2idHasher{}}
+)
+
+func hasherFor(hash []byte) (PasswordHasher, error) {
+	if PreferredHasher.Handles(hash) {
+		return PreferredHasher, nil
+	}
+
+	for _, hasher := range knownHashers {
+		if hasher.Handles(hash) {
+			return hasher, nil
+		}
+	}
+
+	return nil, ErrUnknownHashFormat
+}
+
+type BcryptHasher struct {
+	Cost int
+}
+
+func (h BcryptHasher) Hash(plaintext string) ([]byte, error) {
+	return bcrypt.GenerateFromPassword([]byte(plaintext), h.Cost)
+}
+
+func (h BcryptHasher) Matches(hash []byte, plaintext string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword(hash, []byte(plaintext))
+	if err != nil {
+		switch {
+		case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func (h BcryptHasher) Handles(hash []byte) bool {
+	return bytes.HasPrefix(hash, []byte("$2a$")) || bytes.HasPrefix(hash, []byte("$2b$")) || bytes.HasPrefix(hash, []byte("$2y$"))
+}
+
+func (h BcryptHasher) Outdated(hash []byte) bool {
+	cost, err := bcrypt.Cost(hash)
+	if err != nil {
+		return true
+	}
+	return cost < h.Cost
+}
+
+// Argon2idHasher stores hashes in the PHC string format, $argon2id$v=19$m=65536,t=3,p=2$salt$key
+type Argon2idHasher struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+type argon2idParams struct {
+	memory      uint32
+	iterations  uint32
+	parallelism uint8
+	salt        []byte
+	key         []byte
+}
+
+func (h Argon2idHasher) Hash(plaintext string) ([]byte, error) {
+	salt := make([]byte, h.SaltLength)
+
+	_, err := rand.Read(salt)
+	if err != nil {
+		return nil, err
+	}
+
+	key := argon2.IDKey([]byte(plaintext), salt, h.Iterations, h.Memory, h.Parallelism, h.KeyLength)
+
+	encoded := fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, h.Memory, h.Iterations, h.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+
+	return []byte(encoded), nil
+}
+
+func (h Argon2idHasher) Matches(hash []byte, plaintext string) (bool, error) {
+	params, err := decodeArgon2id(hash)
+	if err != nil {
+		return false, err
+	}
+
+	key := argon2.IDKey([]byte(plaintext), params.salt, params.iterations, params.memory, params.parallelism, uint32(len(params.key)))
+
+	return subtle.ConstantTimeCompare(key, params.key) == 1, nil
+}
+
+func (h Argon2idHasher) Handles(hash []byte) bool {
+	return bytes.HasPrefix(hash, []byte("$argon2id$"))
+}
+
+func (h Argon2idHasher) Outdated(hash []byte) bool {
+	params, err := decodeArgon2id(hash)
+	if err != nil {
+		return true
+	}
+
+	return params.memory < h.Memory ||
+		params.iterations < h.Iterations ||
+		params.parallelism < h.Parallelism ||
+		uint32(len(params.salt)) < h.SaltLength ||
+		uint32(len(params.key)) < h.KeyLength
+}
+
+func decodeArgon2id(hash []byte) (*argon2idParams, error) {
+	parts := bytes.Split(hash, []byte("$"))
+	if len(parts) != 6 || string(parts[1]) != "argon2id" {
+		return nil, ErrUnknownHashFormat
+	}
+
+	var version int
+	_, err := fmt.Sscanf(string(parts[2]), "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return nil, ErrUnknownHashFormat
+	}
+
+	var params argon2idParams
+
+	_, err = fmt.Sscanf(string(parts[3]), "m=%d,t=%d,p=%d", &params.memory, &params.iterations, &params.parallelism)
+	if err != nil {
+		return nil, ErrUnknownHashFormat
+	}
+
+	params.salt, err = base64.RawStdEncoding.DecodeString(string(parts[4]))
+	if err != nil {
+		return nil, ErrUnknownHashFormat
+	}
+
+	params.key, err = base64.RawStdEncoding.DecodeString(string(parts[5]))
+	if err != nil {
+		return nil, ErrUnknownHashFormat
+	}
+
+	return &params, nil
+}
@@ -0,0 +1,102 @@
+package data
+
+import (
+	"errors"
+	"golang.org/x/crypto/bcrypt"
+	"testing"
+)
+
+var testArgon2id = Argon2idHasher{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+
+func TestPasswordHashers(t *testing.T) {
+	tests := []struct {
+		Name   string
+		hasher PasswordHasher
+	}{
+		{"bcrypt", BcryptHasher{Cost: bcrypt.MinCost}},
+		{"argon2id", testArgon2id},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			hash, err := tt.hasher.Hash("pa55word1234")
+			if err != nil {
+				t.Fatalf("Failed to hash password: %v", err)
+			}
+
+			if !tt.hasher.Handles(hash) {
+				t.Errorf("Expected the hasher to recognise its own hash %q", hash)
+			}
+
+			if tt.hasher.Outdated(hash) {
+				t.Error("Expected a fresh hash not to be outdated")
+			}
+
+			match, err := tt.hasher.Matches(hash, "pa55word1234")
+			if err != nil || !match {
+				t.Errorf("Expected the password to match, got match: %t, error: %v", match, err)
+			}
+
+			match, err = tt.hasher.Matches(hash, "wrong password")
+			if err != nil || match {
+				t.Errorf("Expected the password not to match, got match: %t, error: %v", match, err)
+			}
+		})
+	}
+}
+
+func TestPasswordHashers_Outdated(t *testing.T) {
+	weakBcrypt, _ := BcryptHasher{Cost: bcrypt.MinCost}.Hash("pa55word1234")
+
+	if !(BcryptHasher{Cost: bcrypt.MinCost + 1}).Outdated(weakBcrypt) {
+		t.Error("Expected a lower bcrypt cost to be outdated")
+	}
+
+	weakArgon2id, _ := testArgon2id.Hash("pa55word1234")
+
+	stronger := testArgon2id
+	stronger.Iterations = 2
+
+	if !stronger.Outdated(weakArgon2id) {
+		t.Error("Expected fewer argon2id iterations to be outdated")
+	}
+}
+
+func TestPassword_Matches(t *testing.T) {
+	defer func(previous PasswordHasher) { PreferredHasher = previous }(PreferredHasher)
+
+	PreferredHasher = BcryptHasher{Cost: bcrypt.MinCost}
+
+	var p Password
+	if err := p.Set("pa55word1234"); err != nil {
+		t.Fatalf("Failed to set password: %v", err)
+	}
+
+	if p.NeedsRehash() {
+		t.Error("Expected a hash from the preferred hasher not to need rehashing")
+	}
+
+	// a bcrypt hash is still accepted after switching algorithms, but flagged for an upgrade
+	PreferredHasher = testArgon2id
+
+	match, err := p.Matches("pa55word1234")
+	if err != nil || !match {
+		t.Errorf("Expected the password to match, got match: %t, error: %v", match, err)
+	}
+
+	if !p.NeedsRehash() {
+		t.Error("Expected a bcrypt hash to need rehashing once argon2id is preferred")
+	}
+
+	corrupt := Password{hash: []byte("not a hash")}
+
+	if match, err := corrupt.Matches("pa55word1234"); match || !errors.Is(err, ErrUnknownHashFormat) {
+		t.Errorf("Expected ErrUnknownHashFormat and no match, got match: %t, error: %v", match, err)
+	}
+
+	truncated := Password{hash: []byte("$2a$04$tooshort")}
+
+	if match, err := truncated.Matches("pa55word1234"); match || err == nil {
+		t.Errorf("Expected a bcrypt error and no match, got match: %t, error: %v", match, err)
+	}
+}
@@ -0,0 +1,53 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"movie_api/internal/validator"
+	"time"
+)
+
+// Invitation is stored as the payload of an invite token, the token's user is whoever sent it
+type Invitation struct {
+	Email       string      `json:"email"`
+	Permissions Permissions `json:"permissions"`
+}
+
+func ValidateInvitation(v *validator.Validator, invitation *Invitation) {
+	ValidateEmail(v, invitation.Email)
+	v.Check(validator.Unique(invitation.Permissions), "permissions", "must not contain duplicate values")
+}
+
+func (m TokenModel) NewInvitation(ctx context.Context, inviterID int64, ttl time.Duration, invitation *Invitation) (*Token, error) {
+	return newInvitation(ctx, m, inviterID, ttl, invitation)
+}
+
+func (m TokenModel) GetInvitation(ctx context.Context, tokenPlaintext string) (*Invitation, error) {
+	return getInvitation(ctx, m, tokenPlaintext)
+}
+
+// newInvitation and getInvitation only need payload tokens, so every TokenRepository shares them
+func newInvitation(ctx context.Context, tokens TokenRepository, inviterID int64, ttl time.Duration, invitation *Invitation) (*Token, error) {
+	payload, err := json.Marshal(invitation)
+	if err != nil {
+		return nil, err
+	}
+
+	return tokens.NewWithPayload(ctx, inviterID, ttl, ScopeInvite, string(payload))
+}
+
+func getInvitation(ctx context.Context, tokens TokenRepository, tokenPlaintext string) (*Invitation, error) {
+	payload, err := tokens.GetPayload(ctx, ScopeInvite, tokenPlaintext)
+	if err != nil {
+		return nil, err
+	}
+
+	var invitation Invitation
+
+	err = json.Unmarshal([]byte(payload), &invitation)
+	if err != nil {
+		return nil, err
+	}
+
+	return &invitation, nil
+}
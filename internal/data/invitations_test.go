@@ -0,0 +1,31 @@
+package data
+
+import (
+	"movie_api/internal/validator"
+	"testing"
+)
+
+func TestValidateInvitation(t *testing.T) {
+	tests := []struct {
+		Name       string
+		invitation Invitation
+		valid      bool
+	}{
+		{"Valid invitation", Invitation{Email: "alice@example.com", Permissions: Permissions{"movies:write"}}, true},
+		{"Valid without permissions", Invitation{Email: "alice@example.com"}, true},
+		{"Invalid email", Invitation{Email: "not-an-email"}, false},
+		{"Duplicate permissions", Invitation{Email: "alice@example.com", Permissions: Permissions{"movies:write", "movies:write"}}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			v := validator.New()
+
+			ValidateInvitation(v, &tt.invitation)
+
+			if v.Valid() != tt.valid {
+				t.Errorf("Expected valid to be %t, got errors: %v", tt.valid, v.Errors)
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+type Lockout struct {
+	UserID         int64
+	FailedAttempts int
+	LastFailedAt   time.Time
+	LockedUntil    *time.Time
+}
+
+// Locked reports whether the account is inside a lockout window
+func (l *Lockout) Locked(now time.Time) bool {
+	return l.LockedUntil != nil && now.Before(*l.LockedUntil)
+}
+
+// NextAttempt is the earliest time another login attempt is allowed given the failures so far
+func (l *Lockout) NextAttempt(base, max time.Duration) time.Time {
+	return l.LastFailedAt.Add(Backoff(l.FailedAttempts, base, max))
+}
+
+// Backoff doubles the delay with every failure after the first, capped at max
+func Backoff(failures int, base, max time.Duration) time.Duration {
+	if failures < 1 {
+		return 0
+	}
+
+	delay := base
+	for i := 1; i < failures && delay < max; i++ {
+		delay *= 2
+	}
+
+	if delay > max {
+		return max
+	}
+	return delay
+}
+
+type LockoutModel struct {
+	DB DBTX
+}
+
+// Get returns the lockout state for a user, a user with no failures gets an empty lockout rather than an error
+func (m LockoutModel) Get(ctx context.Context, userID int64) (*Lockout, error) {
+	query := `
+		SELECT user_id, failed_attempts, last_failed_at, locked_until
+		FROM account_lockouts
+		WHERE user_id = $1`
+
+	lockout := Lockout{UserID: userID}
+
+	ctx, done := startQuery(ctx, "LockoutModel.Get")
+	defer done()
+
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(
+		&lockout.UserID,
+		&lockout.FailedAttempts,
+		&lockout.LastFailedAt,
+		&lockout.LockedUntil,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return &lockout, nil
+		default:
+			return nil, err
+		}
+	}
+
+	return &lockout, nil
+}
+
+// RecordFailure counts a failed login, locking the account for lockFor once maxFailures is reached.
+// The failure count is reset when the lock is applied so the account starts fresh once it expires.
+// Counting and locking happen in one statement, so concurrent failures can't both slip past the threshold.
+func (m LockoutModel) RecordFailure(ctx context.Context, userID int64, maxFailures int, lockFor time.Duration) (*Lockout, error) {
+	query := `
+		INSERT INTO account_lockouts AS l (user_id, failed_attempts, last_failed_at, locked_until)
+		VALUES (
+			$1,
+			CASE WHEN 1 >= $2 THEN 0 ELSE 1 END,
+			NOW(),
+			CASE WHEN 1 >= $2 THEN $3::timestamptz END
+		)
+		ON CONFLICT (user_id) DO UPDATE
+		SET failed_attempts = CASE WHEN l.failed_attempts + 1 >= $2 THEN 0 ELSE l.failed_attempts + 1 END,
+			last_failed_at = NOW(),
+			locked_until = CASE WHEN l.failed_attempts + 1 >= $2 THEN $3 ELSE l.locked_until END
+		RETURNING failed_attempts, last_failed_at, locked_until`
+
+	lockout := Lockout{UserID: userID}
+
+	ctx, done := startQuery(ctx, "LockoutModel.RecordFailure")
+	defer done()
+
+	err := m.DB.QueryRowContext(ctx, query, userID, maxFailures, time.Now().Add(lockFor)).Scan(
+		&lockout.FailedAttempts,
+		&lockout.LastFailedAt,
+		&lockout.LockedUntil,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &lockout, nil
+}
+
+func (m LockoutModel) Reset(ctx context.Context, userID int64) error {
+	query := `
+		DELETE FROM account_lockouts
+		WHERE user_id = $1`
+
+	ctx, done := startQuery(ctx, "LockoutModel.Reset")
+	defer done()
+
+	_, err := m.DB.ExecContext(ctx, query, userID)
+	return err
+}
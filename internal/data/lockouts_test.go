@@ -0,0 +1,57 @@
+package data
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		Name     string
+		failures int
+		expected time.Duration
+	}{
+		{"No failures", 0, 0},
+		{"First failure", 1, time.Second},
+		{"Third failure doubles twice", 3, 4 * time.Second},
+		{"Capped at max", 10, 30 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			got := Backoff(tt.failures, time.Second, 30*time.Second)
+			if got != tt.expected {
+				t.Errorf("Unexpected backoff. Expected: %s, Got: %s", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestLockout_Locked(t *testing.T) {
+	now := time.Now()
+	future := now.Add(time.Minute)
+	past := now.Add(-time.Minute)
+
+	if (&Lockout{}).Locked(now) {
+		t.Error("Expected a lockout without an expiry to be unlocked")
+	}
+
+	if !(&Lockout{LockedUntil: &future}).Locked(now) {
+		t.Error("Expected a lockout expiring in the future to be locked")
+	}
+
+	if (&Lockout{LockedUntil: &past}).Locked(now) {
+		t.Error("Expected an expired lockout to be unlocked")
+	}
+}
+
+func TestLockout_NextAttempt(t *testing.T) {
+	now := time.Now()
+
+	lockout := &Lockout{FailedAttempts: 2, LastFailedAt: now}
+
+	expected := now.Add(2 * time.Second)
+	if got := lockout.NextAttempt(time.Second, time.Minute); !got.Equal(expected) {
+		t.Errorf("Unexpected next attempt. Expected: %s, Got: %s", expected, got)
+	}
+}
@@ -0,0 +1,873 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// memoryDB holds the tables behind NewMemoryModels. A single mutex guards all of them, the memory backend is
+// for tests and local development so simplicity wins over contention.
+type memoryDB struct {
+	mu     sync.Mutex
+	tables memoryTables
+}
+
+type memoryTables struct {
+	movies map[int64]Movie
+	users  map[int64]User
+	// tokens and recoveryCodes are keyed by hash, like their primary keys in postgres
+	tokens        map[string]Token
+	recoveryCodes map[string]int64
+	permissions   map[int64]Permissions
+	lockouts      map[int64]Lockout
+	deletions     map[int64]AccountDeletion
+	// totpSteps is users.totp_last_step, kept apart since it isn't a field of User
+	totpSteps   map[int64]int64
+	lastMovieID int64
+	lastUserID  int64
+}
+
+func newMemoryTables() memoryTables {
+	return memoryTables{
+		movies:        make(map[int64]Movie),
+		users:         make(map[int64]User),
+		tokens:        make(map[string]Token),
+		recoveryCodes: make(map[string]int64),
+		permissions:   make(map[int64]Permissions),
+		lockouts:      make(map[int64]Lockout),
+		deletions:     make(map[int64]AccountDeletion),
+		totpSteps:     make(map[int64]int64),
+	}
+}
+
+// clone copies every table. Stored values are replaced rather than modified in place, so copying the maps
+// is enough to take a snapshot.
+func (t memoryTables) clone() memoryTables {
+	c := newMemoryTables()
+	for k, v := range t.movies {
+		c.movies[k] = v
+	}
+	for k, v := range t.users {
+		c.users[k] = v
+	}
+	for k, v := range t.tokens {
+		c.tokens[k] = v
+	}
+	for k, v := range t.recoveryCodes {
+		c.recoveryCodes[k] = v
+	}
+	for k, v := range t.permissions {
+		c.permissions[k] = v
+	}
+	for k, v := range t.lockouts {
+		c.lockouts[k] = v
+	}
+	for k, v := range t.deletions {
+		c.deletions[k] = v
+	}
+	for k, v := range t.totpSteps {
+		c.totpSteps[k] = v
+	}
+	c.lastMovieID = t.lastMovieID
+	c.lastUserID = t.lastUserID
+	return c
+}
+
+// NewMemoryModels keeps everything in process, so handlers can be exercised without postgres. Nothing is
+// persisted, each call starts with empty tables.
+func NewMemoryModels() Models {
+	db := &memoryDB{tables: newMemoryTables()}
+
+	models := newMemoryModels(db)
+	models.memory = db
+	return models
+}
+
+func newMemoryModels(db *memoryDB) Models {
+	return Models{
+		Deletions:     memoryDeletions{db: db},
+		Lockouts:      memoryLockouts{db: db},
+		Movies:        memoryMovies{db: db},
+		Permissions:   memoryPermissions{db: db},
+		RecoveryCodes: memoryRecoveryCodes{db: db},
+		Tokens:        memoryTokens{db: db},
+		Users:         memoryUsers{db: db},
+	}
+}
+
+// withTx runs fn against a private copy of the tables that replaces them on commit. The lock is held
+// throughout, so nothing else can write in the meantime and have the copy overwrite it.
+func (db *memoryDB) withTx(fn func(tx Models) error) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	tx := &memoryDB{tables: db.tables.clone()}
+
+	// the tx models have no memory of their own, so WithTx inside fn joins this transaction
+	err := fn(newMemoryModels(tx))
+	if err != nil {
+		return err
+	}
+
+	db.tables = tx.tables
+
+	return nil
+}
+
+type memoryMovies struct {
+	db *memoryDB
+}
+
+func (m memoryMovies) Insert(ctx context.Context, movie *Movie) error {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	m.db.tables.lastMovieID++
+
+	movie.ID = m.db.tables.lastMovieID
+	movie.CreatedAt = time.Now()
+	movie.Version = 1
+
+	stored := *movie
+	stored.Genres = append([]string(nil), movie.Genres...)
+	m.db.tables.movies[movie.ID] = stored
+
+	return nil
+}
+
+// selected copies a stored movie out the way the Get and GetAll queries do, which leave out created_by
+func (m memoryMovies) selected(movie Movie) *Movie {
+	movie.Genres = append([]string(nil), movie.Genres...)
+	movie.CreatedBy = 0
+	return &movie
+}
+
+func (m memoryMovies) Get(ctx context.Context, id int64) (*Movie, error) {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	movie, found := m.db.tables.movies[id]
+	if !found {
+		return nil, ErrRecordNotFound
+	}
+
+	return m.selected(movie), nil
+}
+
+func (m memoryMovies) GetByTitle(ctx context.Context, title string, year int32) (*Movie, error) {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	var oldest *Movie
+
+	for _, movie := range m.db.tables.movies {
+		if movie.Title == title && movie.Year == year && (oldest == nil || movie.ID < oldest.ID) {
+			oldest = m.selected(movie)
+		}
+	}
+
+	if oldest == nil {
+		return nil, ErrRecordNotFound
+	}
+
+	return oldest, nil
+}
+
+func (m memoryMovies) Update(ctx context.Context, movie *Movie) error {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	stored, found := m.db.tables.movies[movie.ID]
+	if !found || stored.Version != movie.Version {
+		return ErrEditConflict
+	}
+
+	stored.Title = movie.Title
+	stored.Year = movie.Year
+	stored.Runtime = movie.Runtime
+	stored.Genres = append([]string(nil), movie.Genres...)
+	stored.Version++
+	m.db.tables.movies[movie.ID] = stored
+
+	movie.Version = stored.Version
+
+	return nil
+}
+
+func (m memoryMovies) Delete(ctx context.Context, id int64) error {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	if _, found := m.db.tables.movies[id]; !found {
+		return ErrRecordNotFound
+	}
+
+	delete(m.db.tables.movies, id)
+
+	return nil
+}
+
+func (m memoryMovies) GetAll(ctx context.Context, title string, genres []string, filters Filters) ([]*Movie, Metadata, error) {
+	column, descending := filters.SortColumn(), filters.SortDirection() == "DESC"
+
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	matched := []Movie{}
+	for _, movie := range m.db.tables.movies {
+		if matchesTitle(movie.Title, title) && containsAll(movie.Genres, genres) {
+			matched = append(matched, movie)
+		}
+	}
+
+	sort.Slice(matched, func(i, j int) bool {
+		c := compareMovies(matched[i], matched[j], column)
+		if c == 0 {
+			return matched[i].ID < matched[j].ID
+		}
+		if descending {
+			return c > 0
+		}
+		return c < 0
+	})
+
+	movies := []*Movie{}
+	for i := filters.Offset(); i < len(matched) && len(movies) < filters.Limit(); i++ {
+		movies = append(movies, m.selected(matched[i]))
+	}
+
+	// the count comes back on each row in postgres, so a page past the end reports no records at all
+	totalRecords := 0
+	if len(movies) > 0 {
+		totalRecords = len(matched)
+	}
+
+	return movies, CalculateMetaData(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+func (m memoryMovies) GetAllForCreator(ctx context.Context, userID int64) ([]*Movie, error) {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	movies := []*Movie{}
+	for _, movie := range m.db.tables.movies {
+		if movie.CreatedBy == userID {
+			movie.Genres = append([]string(nil), movie.Genres...)
+			movies = append(movies, &movie)
+		}
+	}
+
+	sort.Slice(movies, func(i, j int) bool { return movies[i].ID < movies[j].ID })
+
+	return movies, nil
+}
+
+// matchesTitle approximates to_tsvector('simple', title) @@ plainto_tsquery('simple', query), every word in
+// the query has to appear as a whole word in the title, ignoring case and punctuation
+func matchesTitle(title, query string) bool {
+	if query == "" {
+		return true
+	}
+
+	queryWords := searchWords(query)
+	if len(queryWords) == 0 {
+		return false
+	}
+
+	titleWords := make(map[string]bool)
+	for _, word := range searchWords(title) {
+		titleWords[word] = true
+	}
+
+	for _, word := range queryWords {
+		if !titleWords[word] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func searchWords(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// containsAll mirrors the genres @> $2 array containment
+func containsAll(have, want []string) bool {
+	for _, w := range want {
+		found := false
+		for _, h := range have {
+			if h == w {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func compareMovies(a, b Movie, column string) int {
+	switch column {
+	case "title":
+		return strings.Compare(a.Title, b.Title)
+	case "year":
+		return int(a.Year) - int(b.Year)
+	case "runtime":
+		return int(a.Runtime) - int(b.Runtime)
+	default:
+		switch {
+		case a.ID < b.ID:
+			return -1
+		case a.ID > b.ID:
+			return 1
+		}
+		return 0
+	}
+}
+
+type memoryUsers struct {
+	db *memoryDB
+}
+
+// loadUser fills in what the postgres queries read from other tables, the caller must hold the lock
+func (t memoryTables) loadUser(user User) *User {
+	_, user.DeletionPending = t.deletions[user.ID]
+	return &user
+}
+
+// emailTaken checks the citext unique constraint on users.email, ignoring the user with id except
+func (m memoryUsers) emailTaken(email string, except int64) bool {
+	for _, user := range m.db.tables.users {
+		if user.ID != except && strings.EqualFold(user.Email, email) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m memoryUsers) Insert(ctx context.Context, user *User) error {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	if m.emailTaken(user.Email, 0) {
+		return ErrDuplicateEmail
+	}
+
+	m.db.tables.lastUserID++
+
+	user.ID = m.db.tables.lastUserID
+	user.CreatedAt = time.Now()
+	user.Version = 1
+
+	stored := *user
+	stored.Password.plaintext = nil
+	m.db.tables.users[user.ID] = stored
+
+	return nil
+}
+
+func (m memoryUsers) Get(ctx context.Context, id int64) (*User, error) {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	user, found := m.db.tables.users[id]
+	if !found {
+		return nil, ErrRecordNotFound
+	}
+
+	return m.db.tables.loadUser(user), nil
+}
+
+func (m memoryUsers) GetByEmail(ctx context.Context, email string) (*User, error) {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	for _, user := range m.db.tables.users {
+		if strings.EqualFold(user.Email, email) {
+			return m.db.tables.loadUser(user), nil
+		}
+	}
+
+	return nil, ErrRecordNotFound
+}
+
+func (m memoryUsers) GetForToken(ctx context.Context, tokenScope, tokenPlaintext string) (*User, error) {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	token, found := m.db.tables.validToken(tokenScope, tokenPlaintext)
+	if !found {
+		return nil, ErrRecordNotFound
+	}
+
+	user, found := m.db.tables.users[token.UserID]
+	if !found {
+		return nil, ErrRecordNotFound
+	}
+
+	return m.db.tables.loadUser(user), nil
+}
+
+func (m memoryUsers) Update(ctx context.Context, user *User) error {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	stored, found := m.db.tables.users[user.ID]
+	if !found || stored.Version != user.Version {
+		return ErrEditConflict
+	}
+
+	if m.emailTaken(user.Email, user.ID) {
+		return ErrDuplicateEmail
+	}
+
+	stored.Name = user.Name
+	stored.Email = user.Email
+	stored.Password.hash = user.Password.hash
+	stored.Activated = user.Activated
+	stored.TOTPSecret = user.TOTPSecret
+	stored.TOTPEnabled = user.TOTPEnabled
+	stored.Version++
+	m.db.tables.users[user.ID] = stored
+
+	user.Version = stored.Version
+
+	return nil
+}
+
+func (m memoryUsers) AcceptTOTPStep(ctx context.Context, userID, step int64) (bool, error) {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	if _, found := m.db.tables.users[userID]; !found || m.db.tables.totpSteps[userID] >= step {
+		return false, nil
+	}
+
+	m.db.tables.totpSteps[userID] = step
+
+	return true, nil
+}
+
+func (m memoryUsers) BumpTokenGeneration(ctx context.Context, userID int64) error {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	stored, found := m.db.tables.users[userID]
+	if !found {
+		return ErrRecordNotFound
+	}
+
+	stored.TokenGeneration++
+	m.db.tables.users[userID] = stored
+
+	return nil
+}
+
+type memoryTokens struct {
+	db *memoryDB
+}
+
+// validToken finds an unexpired token for scope, the caller must hold the lock
+func (t memoryTables) validToken(scope, tokenPlaintext string) (Token, bool) {
+	hash := sha256.Sum256([]byte(tokenPlaintext))
+
+	token, found := t.tokens[string(hash[:])]
+	if !found || token.Scope != scope || !token.Expiry.After(time.Now()) {
+		return Token{}, false
+	}
+
+	return token, true
+}
+
+func (m memoryTokens) New(ctx context.Context, userID int64, ttl time.Duration, scope string) (*Token, error) {
+	token, err := generateToken(userID, ttl, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.Insert(ctx, token)
+	return token, err
+}
+
+func (m memoryTokens) NewSession(ctx context.Context, userID int64, ttl time.Duration, clientIP string) (*Token, error) {
+	token, err := generateToken(userID, ttl, ScopeAuthentication)
+	if err != nil {
+		return nil, err
+	}
+
+	token.ClientIP = clientIP
+
+	err = m.Insert(ctx, token)
+	return token, err
+}
+
+func (m memoryTokens) NewWithPayload(ctx context.Context, userID int64, ttl time.Duration, scope, payload string) (*Token, error) {
+	token, err := generateToken(userID, ttl, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	token.Payload = payload
+
+	err = m.Insert(ctx, token)
+	return token, err
+}
+
+func (m memoryTokens) NewInvitation(ctx context.Context, inviterID int64, ttl time.Duration, invitation *Invitation) (*Token, error) {
+	return newInvitation(ctx, m, inviterID, ttl, invitation)
+}
+
+func (m memoryTokens) GetInvitation(ctx context.Context, tokenPlaintext string) (*Invitation, error) {
+	return getInvitation(ctx, m, tokenPlaintext)
+}
+
+func (m memoryTokens) Insert(ctx context.Context, token *Token) error {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	stored := *token
+	stored.Plaintext = ""
+	m.db.tables.tokens[string(token.Hash)] = stored
+
+	return nil
+}
+
+func (m memoryTokens) GetPayload(ctx context.Context, scope, tokenPlaintext string) (string, error) {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	token, found := m.db.tables.validToken(scope, tokenPlaintext)
+	if !found {
+		return "", ErrRecordNotFound
+	}
+
+	return token.Payload, nil
+}
+
+func (m memoryTokens) GetAllForUser(ctx context.Context, userID int64) ([]*Token, error) {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	now := time.Now()
+
+	tokens := []*Token{}
+	for _, token := range m.db.tables.tokens {
+		if token.UserID == userID && token.Expiry.After(now) {
+			// only the columns the sql selects
+			tokens = append(tokens, &Token{UserID: token.UserID, Expiry: token.Expiry, Scope: token.Scope, ClientIP: token.ClientIP})
+		}
+	}
+
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].Expiry.Before(tokens[j].Expiry) })
+
+	return tokens, nil
+}
+
+func (m memoryTokens) Consume(ctx context.Context, scope, tokenPlaintext string) (int64, error) {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	token, found := m.db.tables.validToken(scope, tokenPlaintext)
+	if !found {
+		return 0, ErrRecordNotFound
+	}
+
+	delete(m.db.tables.tokens, string(token.Hash))
+
+	return token.UserID, nil
+}
+
+func (m memoryTokens) ConsumeWithPayload(ctx context.Context, scope, tokenPlaintext string) (int64, string, error) {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	token, found := m.db.tables.validToken(scope, tokenPlaintext)
+	if !found {
+		return 0, "", ErrRecordNotFound
+	}
+
+	delete(m.db.tables.tokens, string(token.Hash))
+
+	return token.UserID, token.Payload, nil
+}
+
+func (m memoryTokens) DeleteAllForUser(ctx context.Context, scope string, userID int64) error {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	for hash, token := range m.db.tables.tokens {
+		if token.Scope == scope && token.UserID == userID {
+			delete(m.db.tables.tokens, hash)
+		}
+	}
+
+	return nil
+}
+
+func (m memoryTokens) DeleteAllForUserExcept(ctx context.Context, userID int64, keepPlaintext string) error {
+	keepHash := sha256.Sum256([]byte(keepPlaintext))
+
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	for hash, token := range m.db.tables.tokens {
+		if token.UserID == userID && hash != string(keepHash[:]) {
+			delete(m.db.tables.tokens, hash)
+		}
+	}
+
+	return nil
+}
+
+func (m memoryTokens) DeleteAllForUserAllScopes(ctx context.Context, userID int64) error {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	for hash, token := range m.db.tables.tokens {
+		if token.UserID == userID {
+			delete(m.db.tables.tokens, hash)
+		}
+	}
+
+	return nil
+}
+
+type memoryPermissions struct {
+	db *memoryDB
+}
+
+// permissionCodes stands in for the rows the migrations insert into permissions, a test keeps the two in step
+var permissionCodes = []string{"movies:read", "movies:write", "users:invite", "debug:read"}
+
+func (m memoryPermissions) GetAll(ctx context.Context) (Permissions, error) {
+	return append(Permissions(nil), permissionCodes...), nil
+}
+
+func (m memoryPermissions) GetAllForUser(ctx context.Context, userID int64) (Permissions, error) {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	if _, found := m.db.tables.users[userID]; !found {
+		return nil, nil
+	}
+
+	return append(Permissions(nil), m.db.tables.permissions[userID]...), nil
+}
+
+func (m memoryPermissions) AddForUser(ctx context.Context, userID int64, codes ...string) error {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	permissions := append(Permissions(nil), m.db.tables.permissions[userID]...)
+
+	for _, code := range permissionCodes {
+		if Permissions(codes).Include(code) && !permissions.Include(code) {
+			permissions = append(permissions, code)
+		}
+	}
+
+	m.db.tables.permissions[userID] = permissions
+
+	return nil
+}
+
+type memoryLockouts struct {
+	db *memoryDB
+}
+
+func (m memoryLockouts) Get(ctx context.Context, userID int64) (*Lockout, error) {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	lockout, found := m.db.tables.lockouts[userID]
+	if !found {
+		return &Lockout{UserID: userID}, nil
+	}
+
+	return &lockout, nil
+}
+
+func (m memoryLockouts) RecordFailure(ctx context.Context, userID int64, maxFailures int, lockFor time.Duration) (*Lockout, error) {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	lockout := m.db.tables.lockouts[userID]
+	lockout.UserID = userID
+	lockout.FailedAttempts++
+	lockout.LastFailedAt = time.Now()
+
+	if lockout.FailedAttempts >= maxFailures {
+		lockedUntil := time.Now().Add(lockFor)
+		lockout.FailedAttempts = 0
+		lockout.LockedUntil = &lockedUntil
+	}
+
+	m.db.tables.lockouts[userID] = lockout
+
+	return &lockout, nil
+}
+
+func (m memoryLockouts) Reset(ctx context.Context, userID int64) error {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	delete(m.db.tables.lockouts, userID)
+
+	return nil
+}
+
+type memoryRecoveryCodes struct {
+	db *memoryDB
+}
+
+func (m memoryRecoveryCodes) New(ctx context.Context, userID int64) ([]string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+
+	for i := 0; i < recoveryCodeCount; i++ {
+		plaintext, hash, err := generateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+
+		codes = append(codes, plaintext)
+		hashes = append(hashes, string(hash))
+	}
+
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	m.db.tables.deleteRecoveryCodes(userID)
+
+	for _, hash := range hashes {
+		m.db.tables.recoveryCodes[hash] = userID
+	}
+
+	return codes, nil
+}
+
+func (m memoryRecoveryCodes) Consume(ctx context.Context, userID int64, plaintext string) (bool, error) {
+	hash := sha256.Sum256([]byte(plaintext))
+
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	owner, found := m.db.tables.recoveryCodes[string(hash[:])]
+	if !found || owner != userID {
+		return false, nil
+	}
+
+	delete(m.db.tables.recoveryCodes, string(hash[:]))
+
+	return true, nil
+}
+
+func (m memoryRecoveryCodes) DeleteAllForUser(ctx context.Context, userID int64) error {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	m.db.tables.deleteRecoveryCodes(userID)
+
+	return nil
+}
+
+func (t memoryTables) deleteRecoveryCodes(userID int64) {
+	for hash, owner := range t.recoveryCodes {
+		if owner == userID {
+			delete(t.recoveryCodes, hash)
+		}
+	}
+}
+
+type memoryDeletions struct {
+	db *memoryDB
+}
+
+func (m memoryDeletions) Schedule(ctx context.Context, userID int64, grace time.Duration) (*AccountDeletion, error) {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	deletion, found := m.db.tables.deletions[userID]
+	if !found {
+		now := time.Now()
+		deletion = AccountDeletion{UserID: userID, RequestedAt: now, DeleteAfter: now.Add(grace)}
+		m.db.tables.deletions[userID] = deletion
+	}
+
+	return &deletion, nil
+}
+
+func (m memoryDeletions) Get(ctx context.Context, userID int64) (*AccountDeletion, error) {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	deletion, found := m.db.tables.deletions[userID]
+	if !found {
+		return nil, ErrRecordNotFound
+	}
+
+	return &deletion, nil
+}
+
+func (m memoryDeletions) Cancel(ctx context.Context, userID int64) error {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	if _, found := m.db.tables.deletions[userID]; !found {
+		return ErrRecordNotFound
+	}
+
+	delete(m.db.tables.deletions, userID)
+
+	return nil
+}
+
+// PurgeDue does by hand what the foreign keys do in postgres, dropping everything that cascades from a user
+func (m memoryDeletions) PurgeDue(ctx context.Context) (int, error) {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	now := time.Now()
+	purged := 0
+
+	for userID, deletion := range m.db.tables.deletions {
+		if deletion.DeleteAfter.After(now) {
+			continue
+		}
+
+		for id, movie := range m.db.tables.movies {
+			if movie.CreatedBy == userID {
+				movie.CreatedBy = 0
+				m.db.tables.movies[id] = movie
+			}
+		}
+
+		for hash, token := range m.db.tables.tokens {
+			if token.UserID == userID {
+				delete(m.db.tables.tokens, hash)
+			}
+		}
+
+		m.db.tables.deleteRecoveryCodes(userID)
+		delete(m.db.tables.permissions, userID)
+		delete(m.db.tables.lockouts, userID)
+		delete(m.db.tables.deletions, userID)
+
+		if _, found := m.db.tables.users[userID]; found {
+			delete(m.db.tables.users, userID)
+			purged++
+		}
+	}
+
+	return purged, nil
+}
@@ -11,17 +11,34 @@ var (
 )
 
 type Models struct {
-	Movies      MovieModel
-	Permissions PermissionModel
-	Tokens      TokenModel
-	Users       UserModel
+	// db starts transactions for WithTx, it is nil for models already bound to one
+	db *sql.DB
+	// memory is set instead of db for models from NewMemoryModels
+	memory *memoryDB
+
+	Deletions     DeletionRepository
+	Lockouts      LockoutRepository
+	Movies        MovieRepository
+	Permissions   PermissionRepository
+	RecoveryCodes RecoveryCodeRepository
+	Tokens        TokenRepository
+	Users         UserRepository
 }
 
 func NewModels(db *sql.DB) Models {
+	models := newModels(db)
+	models.db = db
+	return models
+}
+
+func newModels(db DBTX) Models {
 	return Models{
-		Movies:      MovieModel{DB: db},
-		Permissions: PermissionModel{DB: db},
-		Tokens:      TokenModel{DB: db},
-		Users:       UserModel{DB: db},
+		Deletions:     DeletionModel{DB: db},
+		Lockouts:      LockoutModel{DB: db},
+		Movies:        MovieModel{DB: db},
+		Permissions:   PermissionModel{DB: db},
+		RecoveryCodes: RecoveryCodeModel{DB: db},
+		Tokens:        TokenModel{DB: db},
+		Users:         UserModel{DB: db},
 	}
 }
@@ -11,16 +11,20 @@ func TestNewModels(t *testing.T) {
 	models := NewModels(db)
 
 	// Check that the Movies field is initialized correctly
-	if models.Movies.DB != db {
-		t.Errorf("Expected Movies.DB to be %v, got %v", db, models.Movies.DB)
+	if movies, ok := models.Movies.(MovieModel); !ok || movies.DB != db {
+		t.Errorf("Expected Movies to be a MovieModel on %v, got %v", db, models.Movies)
 	}
 
-	if models.Users.DB != db {
-		t.Errorf("Expected Movies.DB to be %v, got %v", db, models.Movies.DB)
+	if users, ok := models.Users.(UserModel); !ok || users.DB != db {
+		t.Errorf("Expected Users to be a UserModel on %v, got %v", db, models.Users)
 	}
 
-	if models.Tokens.DB != db {
-		t.Errorf("Expected Movies.DB to be %v, got %v", db, models.Movies.DB)
+	if codes, ok := models.RecoveryCodes.(RecoveryCodeModel); !ok || codes.DB != db {
+		t.Errorf("Expected RecoveryCodes to be a RecoveryCodeModel on %v, got %v", db, models.RecoveryCodes)
+	}
+
+	if tokens, ok := models.Tokens.(TokenModel); !ok || tokens.DB != db {
+		t.Errorf("Expected Tokens to be a TokenModel on %v, got %v", db, models.Tokens)
 	}
 
 }
@@ -18,6 +18,8 @@ type Movie struct {
 	Runtime   Runtime   `json:"runtime,omitempty"`
 	Genres    []string  `json:"genres,omitempty"`
 	Version   int32     `json:"version"`
+	// CreatedBy is the id of the user who added the movie, zero once that account has been deleted
+	CreatedBy int64 `json:"-"`
 }
 
 func ValidateMovie(v *validator.Validator, movie *Movie) {
@@ -39,25 +41,27 @@ func ValidateMovie(v *validator.Validator, movie *Movie) {
 }
 
 type MovieModel struct {
-	DB *sql.DB
+	DB DBTX
 }
 
-func (m MovieModel) Insert(movie *Movie) error {
+func (m MovieModel) Insert(ctx context.Context, movie *Movie) error {
 	query := `
-		INSERT INTO movies (title, year, runtime, genres)
-		VALUES  ($1, $2, $3, $4)
+		INSERT INTO movies (title, year, runtime, genres, created_by)
+		VALUES  ($1, $2, $3, $4, $5)
 		RETURNING id, created_at, version`
 
-	args := []any{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres)}
+	createdBy := sql.NullInt64{Int64: movie.CreatedBy, Valid: movie.CreatedBy != 0}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+	args := []any{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres), createdBy}
+
+	ctx, done := startQuery(ctx, "MovieModel.Insert")
+	defer done()
 
 	return m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
 
 }
 
-func (m MovieModel) Get(id int64) (*Movie, error) {
+func (m MovieModel) Get(ctx context.Context, id int64) (*Movie, error) {
 	if id < 1 {
 		return nil, ErrRecordNotFound
 	}
@@ -68,8 +72,8 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 		WHERE id = $1`
 	var movie Movie
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+	ctx, done := startQuery(ctx, "MovieModel.Get")
+	defer done()
 
 	// Remove &[]byte{} from the first Scan() destination.
 	err := m.DB.QueryRowContext(ctx, query, id).Scan(&movie.ID,
@@ -86,7 +90,34 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 	return &movie, nil
 }
 
-func (m MovieModel) Update(movie *Movie) error {
+// GetByTitle finds a movie by its exact title and year, the oldest one if there are several
+func (m MovieModel) GetByTitle(ctx context.Context, title string, year int32) (*Movie, error) {
+	query := `
+		SELECT id, created_at, title, year, runtime, genres, version
+		FROM movies
+		WHERE title = $1 AND year = $2
+		ORDER BY id
+		LIMIT 1`
+	var movie Movie
+
+	ctx, done := startQuery(ctx, "MovieModel.GetByTitle")
+	defer done()
+
+	err := m.DB.QueryRowContext(ctx, query, title, year).Scan(&movie.ID,
+		&movie.CreatedAt, &movie.Title, &movie.Year, &movie.Runtime, pq.Array(&movie.Genres), &movie.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &movie, nil
+}
+
+func (m MovieModel) Update(ctx context.Context, movie *Movie) error {
 	query := `
 		UPDATE movies
 		SET title = $1, year = $2, runtime = $3, genres =$4, version = version + 1
@@ -102,8 +133,8 @@ func (m MovieModel) Update(movie *Movie) error {
 		movie.Version,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+	ctx, done := startQuery(ctx, "MovieModel.Update")
+	defer done()
 
 	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.Version)
 	if err != nil {
@@ -118,7 +149,7 @@ func (m MovieModel) Update(movie *Movie) error {
 	return nil
 }
 
-func (m MovieModel) Delete(id int64) error {
+func (m MovieModel) Delete(ctx context.Context, id int64) error {
 	if id < 1 {
 		return ErrRecordNotFound
 	}
@@ -127,8 +158,8 @@ func (m MovieModel) Delete(id int64) error {
 		DELETE FROM movies
 		WHERE id = $1`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+	ctx, done := startQuery(ctx, "MovieModel.Delete")
+	defer done()
 
 	result, err := m.DB.ExecContext(ctx, query, id)
 	if err != nil {
@@ -141,13 +172,13 @@ func (m MovieModel) Delete(id int64) error {
 	}
 
 	if rowsAffected == 0 {
-		return ErrInvalidRuntimeFOrmat
+		return ErrRecordNotFound
 	}
 
 	return nil
 }
 
-func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*Movie, Metadata, error) {
+func (m MovieModel) GetAll(ctx context.Context, title string, genres []string, filters Filters) ([]*Movie, Metadata, error) {
 	query := fmt.Sprintf(`
 		SELECT count(*) OVER(), id, created_at, title, year, runtime, genres, version
 		FROM movies
@@ -155,8 +186,8 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
 		ORDER BY %s %s, id ASC
 		LIMIT $3 OFFSET $4`, filters.SortColumn(), filters.SortDirection())
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+	ctx, done := startQuery(ctx, "MovieModel.GetAll")
+	defer done()
 
 	args := []any{title, pq.Array(genres), filters.Limit(), filters.Offset()}
 
@@ -197,3 +228,48 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
 
 	return movies, metaData, nil
 }
+
+// GetAllForCreator returns every movie a user has added, used for personal data exports
+func (m MovieModel) GetAllForCreator(ctx context.Context, userID int64) ([]*Movie, error) {
+	query := `
+		SELECT id, created_at, title, year, runtime, genres, version, created_by
+		FROM movies
+		WHERE created_by = $1
+		ORDER BY id`
+
+	ctx, done := startQuery(ctx, "MovieModel.GetAllForCreator")
+	defer done()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	movies := []*Movie{}
+
+	for rows.Next() {
+		var movie Movie
+		err := rows.Scan(
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.Version,
+			&movie.CreatedBy,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		movies = append(movies, &movie)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return movies, nil
+}
@@ -2,9 +2,7 @@ package data
 
 import (
 	"context"
-	"database/sql"
 	"github.com/lib/pq"
-	"time"
 )
 
 type Permissions []string
@@ -19,10 +17,45 @@ func (p Permissions) Include(code string) bool {
 }
 
 type PermissionModel struct {
-	DB *sql.DB
+	DB DBTX
 }
 
-func (m PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
+// GetAll lists every permission code the migrations have created, AddForUser ignores any other code
+func (m PermissionModel) GetAll(ctx context.Context) (Permissions, error) {
+	query := `
+		SELECT code
+		FROM permissions
+		ORDER BY id`
+
+	ctx, done := startQuery(ctx, "PermissionModel.GetAll")
+	defer done()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var permissions Permissions
+
+	for rows.Next() {
+		var permission string
+		err := rows.Scan(&permission)
+		if err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, permission)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return permissions, nil
+}
+
+func (m PermissionModel) GetAllForUser(ctx context.Context, userID int64) (Permissions, error) {
 	query := `
 		SELECT permissions.code
 		FROM permissions
@@ -30,8 +63,8 @@ func (m PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
 		INNER JOIN users ON users_permissions.user_id = users.id
 		WHERE users.id = $1`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+	ctx, done := startQuery(ctx, "PermissionModel.GetAllForUser")
+	defer done()
 
 	rows, err := m.DB.QueryContext(ctx, query, userID)
 	if err != nil {
@@ -58,13 +91,13 @@ func (m PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
 	return permissions, nil
 }
 
-func (m PermissionModel) AddForUser(userID int64, codes ...string) error {
+func (m PermissionModel) AddForUser(ctx context.Context, userID int64, codes ...string) error {
 	query := `
 		INSERT INTO users_permissions
 		SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+	ctx, done := startQuery(ctx, "PermissionModel.AddForUser")
+	defer done()
 	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(codes))
 	return err
 }
@@ -0,0 +1,101 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+)
+
+const recoveryCodeCount = 10
+
+type RecoveryCodeModel struct {
+	DB DBTX
+}
+
+func generateRecoveryCode() (string, []byte, error) {
+	randBytes := make([]byte, 10)
+
+	_, err := rand.Read(randBytes)
+	if err != nil {
+		return "", nil, err
+	}
+
+	plaintext := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randBytes)
+
+	hash := sha256.Sum256([]byte(plaintext))
+
+	return plaintext, hash[:], nil
+}
+
+// New replaces any existing recovery codes for the user, only the plaintext codes returned here are ever shown
+func (m RecoveryCodeModel) New(ctx context.Context, userID int64) ([]string, error) {
+	ctx, done := startQuery(ctx, "RecoveryCodeModel.New")
+	defer done()
+
+	codes := make([]string, 0, recoveryCodeCount)
+
+	err := inTx(ctx, m.DB, func(tx DBTX) error {
+		_, err := tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID)
+		if err != nil {
+			return err
+		}
+
+		for i := 0; i < recoveryCodeCount; i++ {
+			plaintext, hash, err := generateRecoveryCode()
+			if err != nil {
+				return err
+			}
+
+			_, err = tx.ExecContext(ctx, `INSERT INTO recovery_codes (hash, user_id) VALUES ($1, $2)`, hash, userID)
+			if err != nil {
+				return err
+			}
+
+			codes = append(codes, plaintext)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// Consume deletes the matching recovery code so it can't be used twice, reporting whether there was one
+func (m RecoveryCodeModel) Consume(ctx context.Context, userID int64, plaintext string) (bool, error) {
+	hash := sha256.Sum256([]byte(plaintext))
+
+	query := `
+		DELETE FROM recovery_codes
+		WHERE hash = $1 AND user_id = $2`
+
+	ctx, done := startQuery(ctx, "RecoveryCodeModel.Consume")
+	defer done()
+
+	result, err := m.DB.ExecContext(ctx, query, hash[:], userID)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected == 1, nil
+}
+
+func (m RecoveryCodeModel) DeleteAllForUser(ctx context.Context, userID int64) error {
+	query := `
+		DELETE FROM recovery_codes
+		WHERE user_id = $1`
+
+	ctx, done := startQuery(ctx, "RecoveryCodeModel.DeleteAllForUser")
+	defer done()
+
+	_, err := m.DB.ExecContext(ctx, query, userID)
+	return err
+}
@@ -0,0 +1,70 @@
+package data
+
+import (
+	"context"
+	"time"
+)
+
+// The repositories below are what handlers depend on. The *Model types implement them on postgres and the
+// memory types in memory.go implement them for tests, conformance_test.go holds both to the same behaviour.
+
+type MovieRepository interface {
+	Insert(ctx context.Context, movie *Movie) error
+	Get(ctx context.Context, id int64) (*Movie, error)
+	GetByTitle(ctx context.Context, title string, year int32) (*Movie, error)
+	Update(ctx context.Context, movie *Movie) error
+	Delete(ctx context.Context, id int64) error
+	GetAll(ctx context.Context, title string, genres []string, filters Filters) ([]*Movie, Metadata, error)
+	GetAllForCreator(ctx context.Context, userID int64) ([]*Movie, error)
+}
+
+type UserRepository interface {
+	Insert(ctx context.Context, user *User) error
+	Get(ctx context.Context, id int64) (*User, error)
+	GetByEmail(ctx context.Context, email string) (*User, error)
+	GetForToken(ctx context.Context, tokenScope, tokenPlaintext string) (*User, error)
+	Update(ctx context.Context, user *User) error
+	AcceptTOTPStep(ctx context.Context, userID, step int64) (bool, error)
+	BumpTokenGeneration(ctx context.Context, userID int64) error
+}
+
+type TokenRepository interface {
+	New(ctx context.Context, userID int64, ttl time.Duration, scope string) (*Token, error)
+	NewSession(ctx context.Context, userID int64, ttl time.Duration, clientIP string) (*Token, error)
+	NewWithPayload(ctx context.Context, userID int64, ttl time.Duration, scope, payload string) (*Token, error)
+	NewInvitation(ctx context.Context, inviterID int64, ttl time.Duration, invitation *Invitation) (*Token, error)
+	Insert(ctx context.Context, token *Token) error
+	GetPayload(ctx context.Context, scope, tokenPlaintext string) (string, error)
+	GetInvitation(ctx context.Context, tokenPlaintext string) (*Invitation, error)
+	GetAllForUser(ctx context.Context, userID int64) ([]*Token, error)
+	Consume(ctx context.Context, scope, tokenPlaintext string) (int64, error)
+	ConsumeWithPayload(ctx context.Context, scope, tokenPlaintext string) (int64, string, error)
+	DeleteAllForUser(ctx context.Context, scope string, userID int64) error
+	DeleteAllForUserExcept(ctx context.Context, userID int64, keepPlaintext string) error
+	DeleteAllForUserAllScopes(ctx context.Context, userID int64) error
+}
+
+type PermissionRepository interface {
+	GetAll(ctx context.Context) (Permissions, error)
+	GetAllForUser(ctx context.Context, userID int64) (Permissions, error)
+	AddForUser(ctx context.Context, userID int64, codes ...string) error
+}
+
+type LockoutRepository interface {
+	Get(ctx context.Context, userID int64) (*Lockout, error)
+	RecordFailure(ctx context.Context, userID int64, maxFailures int, lockFor time.Duration) (*Lockout, error)
+	Reset(ctx context.Context, userID int64) error
+}
+
+type RecoveryCodeRepository interface {
+	New(ctx context.Context, userID int64) ([]string, error)
+	Consume(ctx context.Context, userID int64, plaintext string) (bool, error)
+	DeleteAllForUser(ctx context.Context, userID int64) error
+}
+
+type DeletionRepository interface {
+	Schedule(ctx context.Context, userID int64, grace time.Duration) (*AccountDeletion, error)
+	Get(ctx context.Context, userID int64) (*AccountDeletion, error)
+	Cancel(ctx context.Context, userID int64) error
+	PurgeDue(ctx context.Context) (int, error)
+}
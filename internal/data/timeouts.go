@@ -0,0 +1,60 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"movie_api/internal/trace"
+	"strings"
+	"time"
+)
+
+// QueryTimeouts bounds how long each model operation may run. Operations are named Model.Method, the same
+// names their trace spans use, and any without an override get the default.
+type QueryTimeouts struct {
+	Default   time.Duration
+	Overrides map[string]time.Duration
+}
+
+func (t QueryTimeouts) For(op string) time.Duration {
+	if timeout, ok := t.Overrides[op]; ok {
+		return timeout
+	}
+	return t.Default
+}
+
+// Timeouts is set once at startup from DB_QUERY_TIMEOUT and DB_QUERY_TIMEOUTS, like PreferredHasher. The
+// default here only covers code that never loads the config, such as tests.
+var Timeouts = QueryTimeouts{Default: 3 * time.Second}
+
+// ParseTimeoutOverrides reads a space separated list of op=duration, e.g. "MovieModel.GetAll=5s"
+func ParseTimeoutOverrides(val string) (map[string]time.Duration, error) {
+	overrides := make(map[string]time.Duration)
+
+	for _, field := range strings.Fields(val) {
+		op, duration, found := strings.Cut(field, "=")
+		if !found || op == "" {
+			return nil, fmt.Errorf("malformed query timeout %q, expected op=duration", field)
+		}
+
+		timeout, err := time.ParseDuration(duration)
+		if err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("malformed query timeout %q, duration must be positive", field)
+		}
+
+		overrides[op] = timeout
+	}
+
+	return overrides, nil
+}
+
+// startQuery bounds an operation by its configured timeout and traces it. The caller's context still applies,
+// so a client going away or the server shutting down cancels the query too.
+func startQuery(ctx context.Context, op string) (context.Context, func()) {
+	ctx, cancel := context.WithTimeout(ctx, Timeouts.For(op))
+	ctx, span := trace.Start(ctx, op)
+
+	return ctx, func() {
+		span.Finish()
+		cancel()
+	}
+}
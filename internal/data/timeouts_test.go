@@ -0,0 +1,89 @@
+package data
+
+import (
+	"context"
+	"io"
+	"movie_api/internal/trace"
+	"testing"
+	"time"
+)
+
+func TestParseTimeoutOverrides(t *testing.T) {
+	tests := []struct {
+		Name    string
+		input   string
+		wantErr bool
+	}{
+		{"Empty", "", false},
+		{"Several", "MovieModel.GetAll=5s DeletionModel.PurgeDue=1m", false},
+		{"Missing duration", "MovieModel.GetAll", true},
+		{"Bad duration", "MovieModel.GetAll=soon", true},
+		{"Negative duration", "MovieModel.GetAll=-1s", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			_, err := ParseTimeoutOverrides(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Unexpected error. Expected error: %t, Got: %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestQueryTimeouts_For(t *testing.T) {
+	timeouts := QueryTimeouts{
+		Default:   3 * time.Second,
+		Overrides: map[string]time.Duration{"MovieModel.GetAll": 5 * time.Second},
+	}
+
+	if got := timeouts.For("MovieModel.GetAll"); got != 5*time.Second {
+		t.Errorf("Unexpected timeout. Expected: %s, Got: %s", 5*time.Second, got)
+	}
+
+	if got := timeouts.For("MovieModel.Get"); got != 3*time.Second {
+		t.Errorf("Unexpected timeout. Expected: %s, Got: %s", 3*time.Second, got)
+	}
+}
+
+func TestStartQuery_FollowsCaller(t *testing.T) {
+	parent, cancel := context.WithCancel(context.Background())
+
+	ctx, done := startQuery(parent, "MovieModel.Get")
+	defer done()
+
+	cancel()
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Error("Expected cancelling the caller's context to cancel the query")
+	}
+}
+
+// a query's span has to hang off the request that made it, not start a trace of its own
+func TestStartQuery_ChildSpan(t *testing.T) {
+	tracer := trace.New(trace.NewWriterExporter(io.Discard), 1, nil)
+	trace.SetTracer(tracer)
+	defer trace.SetTracer(nil)
+
+	ctx, request := tracer.Start(context.Background(), "GET /v1/movies/:id", trace.KindServer)
+
+	ctx, done := startQuery(ctx, "MovieModel.Get")
+	query := trace.SpanFromContext(ctx)
+	done()
+	request.Finish()
+
+	if query == nil || query == request {
+		t.Fatal("Expected the query to start a span of its own")
+	}
+
+	if query.Context.TraceID != request.Context.TraceID || query.Parent != request.Context.SpanID {
+		t.Errorf("Expected the query span to be a child of the request span. Got trace %s parent %s, Expected trace %s parent %s",
+			query.Context.TraceID, query.Parent, request.Context.TraceID, request.Context.SpanID)
+	}
+
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Failed to shut down: %v", err)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"encoding/base32"
+	"errors"
 	"movie_api/internal/validator"
 	"time"
 )
@@ -14,6 +15,13 @@ const (
 	ScopeActivation     = "activation"
 	ScopeAuthentication = "authentication"
 	ScopePasswordReset  = "password-reset"
+	ScopeMFA            = "mfa"
+	ScopeUnlock         = "unlock"
+	ScopeEmailChange    = "email-change"
+	ScopeLogin          = "login"
+	ScopeInvite         = "invite"
+	// ScopeDeletionCancel is mailed out when an account is scheduled for deletion, it's the only way back in
+	ScopeDeletionCancel = "deletion-cancel"
 )
 
 type Token struct {
@@ -22,6 +30,10 @@ type Token struct {
 	UserID    int64     `json:"-"`
 	Expiry    time.Time `json:"expiry"`
 	Scope     string    `json:"-"`
+	// Payload carries scope specific state, such as the pending address for an email change
+	Payload string `json:"-"`
+	// ClientIP records where an authentication token was issued to
+	ClientIP string `json:"-"`
 }
 
 func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error) {
@@ -53,40 +65,214 @@ func ValidateTokenPlainText(v *validator.Validator, tokenPlaintext string) {
 }
 
 type TokenModel struct {
-	DB *sql.DB
+	DB DBTX
 }
 
-func (m TokenModel) New(userID int64, ttl time.Duration, scope string) (*Token, error) {
+func (m TokenModel) New(ctx context.Context, userID int64, ttl time.Duration, scope string) (*Token, error) {
 	token, err := generateToken(userID, ttl, scope)
 	if err != nil {
 		return nil, err
 	}
 
-	err = m.Insert(token)
+	err = m.Insert(ctx, token)
 	return token, err
 }
 
-func (m TokenModel) Insert(token *Token) error {
+// NewSession issues an authentication token, remembering the address it was handed to
+func (m TokenModel) NewSession(ctx context.Context, userID int64, ttl time.Duration, clientIP string) (*Token, error) {
+	token, err := generateToken(userID, ttl, ScopeAuthentication)
+	if err != nil {
+		return nil, err
+	}
+
+	token.ClientIP = clientIP
+
+	err = m.Insert(ctx, token)
+	return token, err
+}
+
+func (m TokenModel) NewWithPayload(ctx context.Context, userID int64, ttl time.Duration, scope, payload string) (*Token, error) {
+	token, err := generateToken(userID, ttl, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	token.Payload = payload
+
+	err = m.Insert(ctx, token)
+	return token, err
+}
+
+// GetPayload returns the payload stored against an unexpired token
+func (m TokenModel) GetPayload(ctx context.Context, scope, tokenPlaintext string) (string, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+		SELECT payload
+		FROM tokens
+		WHERE hash = $1 AND scope = $2 AND expiry > $3`
+
+	var payload string
+
+	ctx, done := startQuery(ctx, "TokenModel.GetPayload")
+	defer done()
+
+	err := m.DB.QueryRowContext(ctx, query, tokenHash[:], scope, time.Now()).Scan(&payload)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return "", ErrRecordNotFound
+		default:
+			return "", err
+		}
+	}
+
+	return payload, nil
+}
+
+func (m TokenModel) Insert(ctx context.Context, token *Token) error {
 	query := `
-		INSERT INTO tokens (hash, user_id, expiry, scope) 
-		VALUES ($1, $2, $3, $4)`
-	args := []any{token.Hash, token.UserID, token.Expiry, token.Scope}
+		INSERT INTO tokens (hash, user_id, expiry, scope, payload, client_ip) 
+		VALUES ($1, $2, $3, $4, $5, $6)`
+	args := []any{token.Hash, token.UserID, token.Expiry, token.Scope, token.Payload, token.ClientIP}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+	ctx, done := startQuery(ctx, "TokenModel.Insert")
+	defer done()
 
 	_, err := m.DB.ExecContext(ctx, query, args...)
 	return err
 }
 
-func (m TokenModel) DeleteAllForUser(scope string, userID int64) error {
+func (m TokenModel) DeleteAllForUser(ctx context.Context, scope string, userID int64) error {
 	query := `
 		DELETE FROM tokens
 		WHERE scope = $1 AND user_id = $2`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+	ctx, done := startQuery(ctx, "TokenModel.DeleteAllForUser")
+	defer done()
 
 	_, err := m.DB.ExecContext(ctx, query, scope, userID)
 	return err
 }
+
+// Consume deletes an unexpired token and returns who it belonged to, so it can only ever be used once
+func (m TokenModel) Consume(ctx context.Context, scope, tokenPlaintext string) (int64, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+		DELETE FROM tokens
+		WHERE hash = $1 AND scope = $2 AND expiry > $3
+		RETURNING user_id`
+
+	var userID int64
+
+	ctx, done := startQuery(ctx, "TokenModel.Consume")
+	defer done()
+
+	err := m.DB.QueryRowContext(ctx, query, tokenHash[:], scope, time.Now()).Scan(&userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, ErrRecordNotFound
+		default:
+			return 0, err
+		}
+	}
+
+	return userID, nil
+}
+
+// ConsumeWithPayload deletes an unexpired token and hands back its owner and payload in the same statement, so
+// a token carrying something to act on, like a new email address, can only be acted on once
+func (m TokenModel) ConsumeWithPayload(ctx context.Context, scope, tokenPlaintext string) (int64, string, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+		DELETE FROM tokens
+		WHERE hash = $1 AND scope = $2 AND expiry > $3
+		RETURNING user_id, payload`
+
+	var (
+		userID  int64
+		payload string
+	)
+
+	ctx, done := startQuery(ctx, "TokenModel.ConsumeWithPayload")
+	defer done()
+
+	err := m.DB.QueryRowContext(ctx, query, tokenHash[:], scope, time.Now()).Scan(&userID, &payload)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, "", ErrRecordNotFound
+		default:
+			return 0, "", err
+		}
+	}
+
+	return userID, payload, nil
+}
+
+// DeleteAllForUserExcept clears every token a user has apart from the one given, so nothing issued before a
+// password change, reset links and mfa tokens included, outlives it except the session that made the change
+func (m TokenModel) DeleteAllForUserExcept(ctx context.Context, userID int64, keepPlaintext string) error {
+	keepHash := sha256.Sum256([]byte(keepPlaintext))
+
+	query := `
+		DELETE FROM tokens
+		WHERE user_id = $1 AND hash <> $2`
+
+	ctx, done := startQuery(ctx, "TokenModel.DeleteAllForUserExcept")
+	defer done()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, keepHash[:])
+	return err
+}
+
+// GetAllForUser returns a user's unexpired tokens, only the metadata is available as plaintexts are never stored
+func (m TokenModel) GetAllForUser(ctx context.Context, userID int64) ([]*Token, error) {
+	query := `
+		SELECT user_id, expiry, scope, client_ip
+		FROM tokens
+		WHERE user_id = $1 AND expiry > $2
+		ORDER BY expiry`
+
+	ctx, done := startQuery(ctx, "TokenModel.GetAllForUser")
+	defer done()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	tokens := []*Token{}
+
+	for rows.Next() {
+		var token Token
+		err := rows.Scan(&token.UserID, &token.Expiry, &token.Scope, &token.ClientIP)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, &token)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+func (m TokenModel) DeleteAllForUserAllScopes(ctx context.Context, userID int64) error {
+	query := `
+		DELETE FROM tokens
+		WHERE user_id = $1`
+
+	ctx, done := startQuery(ctx, "TokenModel.DeleteAllForUserAllScopes")
+	defer done()
+
+	_, err := m.DB.ExecContext(ctx, query, userID)
+	return err
+}
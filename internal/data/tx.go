@@ -0,0 +1,103 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/jackc/pgconn"
+	"time"
+)
+
+// DBTX is what the models need from a database handle. Both *sql.DB and *sql.Tx satisfy it, so the same model
+// code runs on its own or as part of a caller's transaction.
+type DBTX interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// maxTxAttempts bounds how often WithTx retries a transaction that lost a serialization race
+const maxTxAttempts = 3
+
+// WithTx runs fn with models bound to one serializable transaction, committing if fn returns nil and rolling
+// back otherwise. Transactions that fail to serialize are retried with a short backoff, so fn may run more
+// than once and shouldn't do anything outside the database, like sending email, that can't be repeated.
+// Calling WithTx on models that are already in a transaction just joins it.
+func (m Models) WithTx(ctx context.Context, fn func(tx Models) error) error {
+	if m.memory != nil {
+		return m.memory.withTx(fn)
+	}
+
+	if m.db == nil {
+		return fn(m)
+	}
+
+	var err error
+
+	for attempt := 1; attempt <= maxTxAttempts; attempt++ {
+		err = m.runTx(ctx, fn)
+		if !isSerializationFailure(err) {
+			return err
+		}
+
+		select {
+		case <-time.After(time.Duration(attempt*attempt) * 10 * time.Millisecond):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return err
+}
+
+func (m Models) runTx(ctx context.Context, fn func(tx Models) error) error {
+	tx, err := m.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = fn(newModels(tx))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// isSerializationFailure spots the errors postgres expects the client to retry. The app connects through pgx,
+// so server errors arrive as *pgconn.PgError.
+func isSerializationFailure(err error) bool {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return false
+	}
+
+	// serialization_failure and deadlock_detected
+	return pgErr.Code == "40001" || pgErr.Code == "40P01"
+}
+
+// inTx runs fn in a transaction of its own, or as part of the caller's when db already is one
+func inTx(ctx context.Context, db DBTX, fn func(tx DBTX) error) error {
+	if tx, ok := db.(*sql.Tx); ok {
+		return fn(tx)
+	}
+
+	sqlDB, ok := db.(*sql.DB)
+	if !ok {
+		return errors.New("data: transactions need a *sql.DB or *sql.Tx")
+	}
+
+	tx, err := sqlDB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = fn(tx)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
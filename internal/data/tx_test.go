@@ -0,0 +1,47 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgconn"
+	"testing"
+)
+
+func TestIsSerializationFailure(t *testing.T) {
+	tests := []struct {
+		Name     string
+		err      error
+		expected bool
+	}{
+		{"Serialization failure", &pgconn.PgError{Code: "40001"}, true},
+		{"Deadlock", &pgconn.PgError{Code: "40P01"}, true},
+		{"Wrapped", fmt.Errorf("insert user: %w", &pgconn.PgError{Code: "40001"}), true},
+		{"Unique violation", &pgconn.PgError{Code: "23505"}, false},
+		{"Other error", errors.New("boom"), false},
+		{"No error", nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			if got := isSerializationFailure(tt.err); got != tt.expected {
+				t.Errorf("Unexpected result. Expected: %t, Got: %t", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestModels_WithTx_Joins(t *testing.T) {
+	// models already bound to a transaction have no db of their own, nested calls run in the outer transaction
+	var tx Models
+
+	calls := 0
+	err := tx.WithTx(context.Background(), func(inner Models) error {
+		calls++
+		return nil
+	})
+
+	if err != nil || calls != 1 {
+		t.Errorf("Expected fn to run once without error, got %d calls and %v", calls, err)
+	}
+}
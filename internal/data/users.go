@@ -5,7 +5,7 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"errors"
-	"golang.org/x/crypto/bcrypt"
+	"movie_api/internal/passwords"
 	"movie_api/internal/validator"
 	"strings"
 	"time"
@@ -18,7 +18,14 @@ type User struct {
 	Email     string    `json:"email"`
 	Password  Password  `json:"-"`
 	Activated bool      `json:"activated"`
-	Version   int       `json:"-"`
+	// TOTPSecret is set when enrolment starts, but only enforced at login once TOTPEnabled is confirmed
+	TOTPSecret  string `json:"-"`
+	TOTPEnabled bool   `json:"totp_enabled"`
+	// TokenGeneration is carried in signed tokens, bumping it ends every signed session issued before
+	TokenGeneration int `json:"-"`
+	// DeletionPending is read from account_deletions, it can't be set through Update
+	DeletionPending bool `json:"-"`
+	Version         int  `json:"-"`
 }
 
 var (
@@ -32,11 +39,11 @@ type Password struct {
 }
 
 type UserModel struct {
-	DB *sql.DB
+	DB DBTX
 }
 
 func (p *Password) Set(plaintextPassword string) error {
-	hash, err := bcrypt.GenerateFromPassword([]byte(plaintextPassword), 12)
+	hash, err := PreferredHasher.Hash(plaintextPassword)
 	if err != nil {
 		return err
 	}
@@ -48,16 +55,18 @@ func (p *Password) Set(plaintextPassword string) error {
 }
 
 func (p *Password) Matches(plaintextPassword string) (bool, error) {
-	err := bcrypt.CompareHashAndPassword(p.hash, []byte(plaintextPassword))
+	hasher, err := hasherFor(p.hash)
 	if err != nil {
-		switch {
-		case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
-			return false, nil
-		default:
-			return true, nil
-		}
+		return false, err
 	}
-	return true, nil
+
+	return hasher.Matches(p.hash, plaintextPassword)
+}
+
+// NeedsRehash reports whether the stored hash should be replaced with one from the PreferredHasher,
+// which can only be done while the plaintext is at hand during a successful login
+func (p *Password) NeedsRehash() bool {
+	return !PreferredHasher.Handles(p.hash) || PreferredHasher.Outdated(p.hash)
 }
 
 func ValidateEmail(v *validator.Validator, email string) {
@@ -71,6 +80,27 @@ func ValidatePasswordPlaintext(v *validator.Validator, password string) {
 	v.Check(len(password) <= 72, "Password", "must not be more than 72 bytes long")
 }
 
+// minPasswordEntropy is roughly eight random lowercase letters and digits
+const minPasswordEntropy = 36
+
+// ValidatePasswordStrength applies the password policy to new passwords, on top of the length checks that every
+// password gets. Logins only use ValidatePasswordPlaintext so existing passwords keep working.
+func ValidatePasswordStrength(v *validator.Validator, password, name, email string) {
+	v.Check(!passwords.Breached(password), "Password", "is too common, it appears in a list of breached passwords")
+
+	lowered := strings.ToLower(password)
+	localPart, _, _ := strings.Cut(strings.ToLower(email), "@")
+
+	for _, personal := range append(strings.Fields(strings.ToLower(name)), localPart) {
+		if len(personal) >= 3 && strings.Contains(lowered, personal) {
+			v.AddError("Password", "must not contain your name or email address")
+			break
+		}
+	}
+
+	v.Check(passwords.Entropy(password) >= minPasswordEntropy, "Password", "is too predictable, try a longer password or mix in other characters")
+}
+
 func ValidateUser(v *validator.Validator, user *User) {
 	v.Check(user.Name != "", "name", "must be provided")
 	v.Check(len(user.Name) <= 500, "name", "must be less than 500 bytes long")
@@ -79,6 +109,7 @@ func ValidateUser(v *validator.Validator, user *User) {
 
 	if user.Password.plaintext != nil {
 		ValidatePasswordPlaintext(v, *user.Password.plaintext)
+		ValidatePasswordStrength(v, *user.Password.plaintext, user.Name, user.Email)
 	}
 
 	// we've messed up if this is hit
@@ -87,7 +118,7 @@ func ValidateUser(v *validator.Validator, user *User) {
 	}
 }
 
-func (m UserModel) Insert(user *User) error {
+func (m UserModel) Insert(ctx context.Context, user *User) error {
 	query := `
 		INSERT INTO USERS (name, email, password_hash, activated)
 		VALUES ($1, $2, $3, $4)
@@ -95,8 +126,8 @@ func (m UserModel) Insert(user *User) error {
 
 	args := []any{user.Name, user.Email, user.Password.hash, user.Activated}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+	ctx, done := startQuery(ctx, "UserModel.Insert")
+	defer done()
 
 	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.Version)
 	if err != nil {
@@ -111,16 +142,17 @@ func (m UserModel) Insert(user *User) error {
 	return nil
 }
 
-func (m UserModel) GetByEmail(email string) (*User, error) {
+func (m UserModel) GetByEmail(ctx context.Context, email string) (*User, error) {
 	query := `
-		SELECT id, created_at, name, email, password_hash, activated, version
+		SELECT id, created_at, name, email, password_hash, activated, totp_secret, totp_enabled, token_generation,
+			EXISTS (SELECT 1 FROM account_deletions WHERE user_id = users.id), version
 		FROM users
 		WHERE email =$1`
 
 	var user User
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+	ctx, done := startQuery(ctx, "UserModel.GetByEmail")
+	defer done()
 
 	err := m.DB.QueryRowContext(ctx, query, email).Scan(
 		&user.ID,
@@ -129,6 +161,52 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 		&user.Email,
 		&user.Password.hash,
 		&user.Activated,
+		&user.TOTPSecret,
+		&user.TOTPEnabled,
+		&user.TokenGeneration,
+		&user.DeletionPending,
+		&user.Version,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &user, nil
+}
+
+func (m UserModel) Get(ctx context.Context, id int64) (*User, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT id, created_at, name, email, password_hash, activated, totp_secret, totp_enabled, token_generation,
+			EXISTS (SELECT 1 FROM account_deletions WHERE user_id = users.id), version
+		FROM users
+		WHERE id = $1`
+
+	var user User
+
+	ctx, done := startQuery(ctx, "UserModel.Get")
+	defer done()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.TOTPSecret,
+		&user.TOTPEnabled,
+		&user.TokenGeneration,
+		&user.DeletionPending,
 		&user.Version,
 	)
 
@@ -144,19 +222,19 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 	return &user, nil
 }
 
-func (m UserModel) Update(user *User) error {
+func (m UserModel) Update(ctx context.Context, user *User) error {
 	query := ` 
 	UPDATE users
-	SET name = $1, email = $2, password_hash = $3, activated = $4, version = version + 1 
-	WHERE id = $5 AND version = $6
+	SET name = $1, email = $2, password_hash = $3, activated = $4, totp_secret = $5, totp_enabled = $6, version = version + 1 
+	WHERE id = $7 AND version = $8
 	RETURNING version`
 
 	args := []any{
-		user.Name, user.Email, user.Password.hash, user.Activated, user.ID, user.Version,
+		user.Name, user.Email, user.Password.hash, user.Activated, user.TOTPSecret, user.TOTPEnabled, user.ID, user.Version,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+	ctx, done := startQuery(ctx, "UserModel.Update")
+	defer done()
 
 	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.Version)
 	if err != nil {
@@ -173,11 +251,64 @@ func (m UserModel) Update(user *User) error {
 	return nil
 }
 
-func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error) {
+// AcceptTOTPStep records step as the user's last accepted TOTP time step. It reports false when that step, or
+// a later one, was already used, the check and the write are one statement so a code can only be spent once.
+func (m UserModel) AcceptTOTPStep(ctx context.Context, userID, step int64) (bool, error) {
+	query := `
+	UPDATE users
+	SET totp_last_step = $2
+	WHERE id = $1 AND totp_last_step < $2`
+
+	ctx, done := startQuery(ctx, "UserModel.AcceptTOTPStep")
+	defer done()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, step)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected == 1, nil
+}
+
+// BumpTokenGeneration ends a user's signed sessions, which can't be deleted like stored tokens. It leaves the
+// version alone so an update already in flight for the same user isn't turned into an edit conflict.
+func (m UserModel) BumpTokenGeneration(ctx context.Context, userID int64) error {
+	query := `
+	UPDATE users
+	SET token_generation = token_generation + 1
+	WHERE id = $1`
+
+	ctx, done := startQuery(ctx, "UserModel.BumpTokenGeneration")
+	defer done()
+
+	result, err := m.DB.ExecContext(ctx, query, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func (m UserModel) GetForToken(ctx context.Context, tokenScope, tokenPlaintext string) (*User, error) {
 	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
 
 	query := `
-	SELECT users.ID, users.created_at, users.name, users.email, users.password_hash, users.activated, users.version
+	SELECT users.ID, users.created_at, users.name, users.email, users.password_hash, users.activated, users.totp_secret, users.totp_enabled, users.token_generation,
+		EXISTS (SELECT 1 FROM account_deletions WHERE user_id = users.id), users.version
 	FROM users
 	INNER JOIN tokens
 	ON users.id = tokens.user_id
@@ -190,8 +321,8 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 
 	var user User
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+	ctx, done := startQuery(ctx, "UserModel.GetForToken")
+	defer done()
 
 	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
 		&user.ID,
@@ -200,6 +331,10 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 		&user.Email,
 		&user.Password.hash,
 		&user.Activated,
+		&user.TOTPSecret,
+		&user.TOTPEnabled,
+		&user.TokenGeneration,
+		&user.DeletionPending,
 		&user.Version,
 	)
 
@@ -0,0 +1,32 @@
+package data
+
+import (
+	"movie_api/internal/validator"
+	"testing"
+)
+
+func TestValidatePasswordStrength(t *testing.T) {
+	tests := []struct {
+		Name     string
+		password string
+		valid    bool
+	}{
+		{"Strong password", "Tr0mbone-Lantern-91", true},
+		{"Breached password", "password123", false},
+		{"Contains name", "xX-alice-rules-Xx", false},
+		{"Contains email local part", "wonderland-2023-Q!", false},
+		{"Low entropy", "zzzzzzzzzzzz", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			v := validator.New()
+
+			ValidatePasswordStrength(v, tt.password, "Alice Liddell", "wonderland@example.com")
+
+			if v.Valid() != tt.valid {
+				t.Errorf("Expected valid to be %t, got errors: %v", tt.valid, v.Errors)
+			}
+		})
+	}
+}
@@ -2,10 +2,13 @@ package jsonlog
 
 import (
 	"encoding/json"
+	"fmt"
 	"io"
 	"os"
 	"runtime/debug"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -26,22 +29,48 @@ func (l Level) String() string {
 		return "ERROR"
 	case LevelFatal:
 		return "FATAL"
+	case LevelOff:
+		return "OFF"
 	default:
 		return ""
 	}
 }
 
+// ParseLevel is the inverse of String, ignoring case
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToUpper(s) {
+	case "INFO":
+		return LevelInfo, nil
+	case "ERROR":
+		return LevelError, nil
+	case "FATAL":
+		return LevelFatal, nil
+	case "OFF":
+		return LevelOff, nil
+	default:
+		return LevelOff, fmt.Errorf("jsonlog: unknown level %q", s)
+	}
+}
+
 type Logger struct {
-	out      io.Writer
-	minLevel Level
+	out io.Writer
+	// minLevel can be changed while the logger is in use, so it's only touched atomically
+	minLevel atomic.Int32
 	mu       sync.Mutex
 }
 
 func New(out io.Writer, minLevel Level) *Logger {
-	return &Logger{
-		out:      out,
-		minLevel: minLevel,
-	}
+	l := &Logger{out: out}
+	l.SetLevel(minLevel)
+	return l
+}
+
+func (l *Logger) Level() Level {
+	return Level(l.minLevel.Load())
+}
+
+func (l *Logger) SetLevel(level Level) {
+	l.minLevel.Store(int32(level))
 }
 
 func (l *Logger) PrintInfo(message string, properties map[string]string) {
@@ -58,7 +87,7 @@ func (l *Logger) PrintFatal(err error, properties map[string]string) {
 }
 
 func (l *Logger) print(level Level, message string, properties map[string]string) (int, error) {
-	if level < l.minLevel {
+	if level < l.Level() {
 		return 0, nil
 	}
 
@@ -0,0 +1,124 @@
+package jwt
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+var (
+	oldSecret = []byte("an-old-secret-that-is-at-least-32-bytes")
+	newSecret = []byte("a-new-secret-that-is-also-at-least-32-bytes")
+)
+
+func TestSigner_SignAndVerify(t *testing.T) {
+	signer, err := New("v1", "movie_api", map[string][]byte{"v1": oldSecret})
+	if err != nil {
+		t.Fatalf("Failed to create signer: %v", err)
+	}
+
+	claims := Claims{
+		Subject:     42,
+		Activated:   true,
+		Permissions: []string{"movies:read"},
+		IssuedAt:    time.Now().Unix(),
+		Expiry:      time.Now().Add(time.Minute).Unix(),
+	}
+
+	token, err := signer.Sign(claims)
+	if err != nil {
+		t.Fatalf("Failed to sign token: %v", err)
+	}
+
+	if !LooksSigned(token) {
+		t.Errorf("Expected %q to look like a signed token", token)
+	}
+
+	got, err := signer.Verify(token)
+	if err != nil {
+		t.Fatalf("Failed to verify token: %v", err)
+	}
+
+	if got.Subject != 42 || !got.Activated || len(got.Permissions) != 1 || got.Issuer != "movie_api" {
+		t.Errorf("Unexpected claims. Got: %+v", got)
+	}
+}
+
+func TestSigner_KeyRotation(t *testing.T) {
+	oldSigner, _ := New("v1", "movie_api", map[string][]byte{"v1": oldSecret})
+
+	token, err := oldSigner.Sign(Claims{Subject: 1, Expiry: time.Now().Add(time.Minute).Unix()})
+	if err != nil {
+		t.Fatalf("Failed to sign token: %v", err)
+	}
+
+	rotated, err := New("v2", "movie_api", map[string][]byte{"v1": oldSecret, "v2": newSecret})
+	if err != nil {
+		t.Fatalf("Failed to create signer: %v", err)
+	}
+
+	if _, err := rotated.Verify(token); err != nil {
+		t.Errorf("Expected token signed by a retired key to verify, got: %v", err)
+	}
+
+	retired, _ := New("v2", "movie_api", map[string][]byte{"v2": newSecret})
+
+	if _, err := retired.Verify(token); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Expected ErrUnknownKey, got: %v", err)
+	}
+}
+
+func TestSigner_VerifySadPaths(t *testing.T) {
+	signer, _ := New("v1", "movie_api", map[string][]byte{"v1": oldSecret})
+
+	expired, _ := signer.Sign(Claims{Subject: 1, Expiry: time.Now().Add(-time.Minute).Unix()})
+	valid, _ := signer.Sign(Claims{Subject: 1, Expiry: time.Now().Add(time.Minute).Unix()})
+
+	// same key, different service
+	other, _ := New("v1", "another_api", map[string][]byte{"v1": oldSecret})
+	foreign, _ := other.Sign(Claims{Subject: 1, Expiry: time.Now().Add(time.Minute).Unix()})
+
+	tests := []struct {
+		Name  string
+		token string
+		err   error
+	}{
+		{"Expired token", expired, ErrExpiredToken},
+		{"Tampered signature", valid[:len(valid)-2] + "AA", ErrInvalidToken},
+		{"Not a token", "ABCDEFGHIJKLMNOPQRSTUVWXYZ", ErrInvalidToken},
+		{"Wrong issuer", foreign, ErrInvalidToken},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			if _, err := signer.Verify(tt.token); !errors.Is(err, tt.err) {
+				t.Errorf("Expected %v, got: %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	keys, err := ParseKeys("v1=secret-one v2=secret-two")
+	if err != nil {
+		t.Fatalf("Failed to parse keys: %v", err)
+	}
+
+	if len(keys) != 2 || string(keys["v2"]) != "secret-two" {
+		t.Errorf("Unexpected keys. Got: %v", keys)
+	}
+
+	if _, err := ParseKeys("v1"); err == nil {
+		t.Error("Expected an error for a key without a secret")
+	}
+}
+
+func TestNew_RejectsBadConfig(t *testing.T) {
+	if _, err := New("v2", "movie_api", map[string][]byte{"v1": oldSecret}); err == nil {
+		t.Error("Expected an error when the active key is missing")
+	}
+
+	if _, err := New("v1", "movie_api", map[string][]byte{"v1": []byte("short")}); err == nil {
+		t.Error("Expected an error for a short secret")
+	}
+}
@@ -2,9 +2,11 @@ package mailer
 
 import (
 	"bytes"
+	"context"
 	"embed"
 	"github.com/go-mail/mail/v2"
 	"html/template"
+	"movie_api/internal/trace"
 	"time"
 )
 
@@ -26,7 +28,16 @@ func New(host string, port int, username, password, sender string) Mailer {
 	}
 }
 
-func (m Mailer) Send(recipient, templateFile string, data any) error {
+// Send renders and delivers a template. ctx only parents the trace span: emails are sent in the background and
+// carry on after the request that queued them has finished.
+func (m Mailer) Send(ctx context.Context, recipient, templateFile string, data any) (err error) {
+	_, span := trace.StartKind(ctx, "Mailer.Send", trace.KindClient)
+	span.SetAttribute("mail.template", templateFile)
+	defer func() {
+		span.SetError(err)
+		span.Finish()
+	}()
+
 	tmpl, err := template.New("email").ParseFS(templateFS, "templates/"+templateFile)
 	if err != nil {
 		return err
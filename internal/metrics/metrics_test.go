@@ -0,0 +1,60 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRegistry_Render(t *testing.T) {
+	reg := NewRegistry()
+
+	requests := reg.NewCounterVec("http_requests_total", "Requests served.", "method", "route")
+	requests.Inc("GET", "/v1/movies/:id")
+	requests.Inc("GET", "/v1/movies/:id")
+	requests.Inc("POST", `/weird"route`)
+
+	latency := reg.NewHistogramVec("http_request_duration_seconds", "Request latency.", []float64{0.1, 1}, "route")
+	latency.Observe(0.05, "/v1/movies")
+	latency.Observe(0.5, "/v1/movies")
+	latency.Observe(5, "/v1/movies")
+
+	reg.NewGaugeFunc("db_open_connections", "Open connections.", func() float64 { return 3 })
+
+	var buf bytes.Buffer
+	reg.Render(&buf)
+	out := buf.String()
+
+	expected := []string{
+		"# TYPE http_requests_total counter",
+		`http_requests_total{method="GET",route="/v1/movies/:id"} 2`,
+		`http_requests_total{method="POST",route="/weird\"route"} 1`,
+		"# TYPE http_request_duration_seconds histogram",
+		`http_request_duration_seconds_bucket{route="/v1/movies",le="0.1"} 1`,
+		`http_request_duration_seconds_bucket{route="/v1/movies",le="1"} 2`,
+		`http_request_duration_seconds_bucket{route="/v1/movies",le="+Inf"} 3`,
+		`http_request_duration_seconds_sum{route="/v1/movies"} 5.55`,
+		`http_request_duration_seconds_count{route="/v1/movies"} 3`,
+		"# TYPE db_open_connections gauge",
+		"db_open_connections 3",
+	}
+
+	for _, line := range expected {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("Expected output to contain %q, Got:\n%s", line, out)
+		}
+	}
+}
+
+func TestRegistry_DuplicateName(t *testing.T) {
+	reg := NewRegistry()
+	reg.NewGaugeFunc("goroutines", "Goroutines.", func() float64 { return 1 })
+
+	defer func() {
+		if err := recover(); err == nil {
+			t.Error("Expected a panic, but no panic occurred")
+		}
+	}()
+
+	reg.NewGaugeFunc("goroutines", "Goroutines.", func() float64 { return 1 })
+}
@@ -0,0 +1,490 @@
+package migrate
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Migration is one numbered change to the schema, read from a pair of <version>_<name>.up.sql and .down.sql files
+type Migration struct {
+	Version int64
+	Name    string
+	UpSQL   string
+	DownSQL string
+	// Checksum is the sha256 of the up file, an applied migration whose file has changed since is refused
+	Checksum string
+}
+
+// Applied is a row of the schema_migrations table
+type Applied struct {
+	Version   int64
+	Name      string
+	Checksum  string
+	AppliedAt time.Time
+}
+
+// Step is a migration run in one direction
+type Step struct {
+	Migration
+	Up bool
+}
+
+var (
+	ErrUnknownVersion = errors.New("migrate: no migration with that version")
+	ErrNoDownFile     = errors.New("migrate: migration has no down file")
+)
+
+// ChecksumError means an applied migration's file was edited afterwards, the schema may no longer match it
+type ChecksumError struct {
+	Version int64
+	Name    string
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("migrate: %d_%s has changed since it was applied", e.Version, e.Name)
+}
+
+// MissingError means the database has a migration applied that this binary doesn't know, usually because an
+// older build is running against a newer schema
+type MissingError struct {
+	Version int64
+}
+
+func (e *MissingError) Error() string {
+	return fmt.Sprintf("migrate: applied migration %d is not in this build", e.Version)
+}
+
+var fileName = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// Load reads migrations from the top of fsys in version order, files not named like a migration are ignored
+func Load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*Migration)
+
+	for _, entry := range entries {
+		match := fileName.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil || version < 1 {
+			return nil, fmt.Errorf("migrate: invalid version in %s", entry.Name())
+		}
+
+		contents, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		m, found := byVersion[version]
+		if !found {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		} else if m.Name != match[2] {
+			return nil, fmt.Errorf("migrate: version %d is used by both %s and %s", version, m.Name, match[2])
+		}
+
+		if match[3] == "up" {
+			sum := sha256.Sum256(contents)
+			m.UpSQL = string(contents)
+			m.Checksum = hex.EncodeToString(sum[:])
+		} else {
+			m.DownSQL = string(contents)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Checksum == "" {
+			return nil, fmt.Errorf("migrate: %d_%s has no up file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	return migrations, nil
+}
+
+// verify refuses to go on if an applied migration has been edited or is unknown to this build
+func verify(migrations []Migration, applied map[int64]Applied) error {
+	known := make(map[int64]Migration, len(migrations))
+	for _, m := range migrations {
+		known[m.Version] = m
+	}
+
+	versions := make([]int64, 0, len(applied))
+	for version := range applied {
+		versions = append(versions, version)
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i] < versions[j] })
+
+	for _, version := range versions {
+		m, found := known[version]
+		if !found {
+			return &MissingError{Version: version}
+		}
+		if m.Checksum != applied[version].Checksum {
+			return &ChecksumError{Version: m.Version, Name: m.Name}
+		}
+	}
+
+	return nil
+}
+
+// plan works out the steps that leave exactly the migrations up to target applied. Pending migrations below
+// the newest applied one, e.g. from a merged branch, are applied too.
+func plan(migrations []Migration, applied map[int64]Applied, target int64) ([]Step, error) {
+	if err := verify(migrations, applied); err != nil {
+		return nil, err
+	}
+
+	steps := []Step{}
+
+	for i := len(migrations) - 1; i >= 0; i-- {
+		m := migrations[i]
+		if _, found := applied[m.Version]; found && m.Version > target {
+			if m.DownSQL == "" {
+				return nil, fmt.Errorf("%w: %d_%s", ErrNoDownFile, m.Version, m.Name)
+			}
+			steps = append(steps, Step{Migration: m, Up: false})
+		}
+	}
+
+	for _, m := range migrations {
+		if _, found := applied[m.Version]; !found && m.Version <= target {
+			steps = append(steps, Step{Migration: m, Up: true})
+		}
+	}
+
+	return steps, nil
+}
+
+// lockID is an arbitrary key for pg_advisory_lock, shared by every instance so only one migrates at a time
+const lockID = 7_426_354_190
+
+// Migrator applies migrations to a postgres database, tracking them in schema_migrations
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration
+}
+
+func New(db *sql.DB, fsys fs.FS) (*Migrator, error) {
+	migrations, err := Load(fsys)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{db: db, migrations: migrations}, nil
+}
+
+// Up applies every pending migration
+func (m *Migrator) Up(ctx context.Context) ([]Step, error) {
+	return m.migrate(ctx, func(applied map[int64]Applied) int64 {
+		return math.MaxInt64
+	})
+}
+
+// Down reverts the most recently applied migration
+func (m *Migrator) Down(ctx context.Context) ([]Step, error) {
+	return m.migrate(ctx, func(applied map[int64]Applied) int64 {
+		var latest, previous int64
+		for version := range applied {
+			if version > latest {
+				latest, previous = version, latest
+			} else if version > previous {
+				previous = version
+			}
+		}
+		return previous
+	})
+}
+
+// Goto migrates up or down until version is the newest migration applied, 0 reverts everything
+func (m *Migrator) Goto(ctx context.Context, version int64) ([]Step, error) {
+	if version != 0 && !m.known(version) {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownVersion, version)
+	}
+
+	return m.migrate(ctx, func(applied map[int64]Applied) int64 {
+		return version
+	})
+}
+
+func (m *Migrator) known(version int64) bool {
+	for _, migration := range m.migrations {
+		if migration.Version == version {
+			return true
+		}
+	}
+	return false
+}
+
+// Status lists every migration this build knows, along with when it was applied
+type Status struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+	// Modified is set when the file no longer matches what was applied
+	Modified bool
+	// NeedsAdoption is set when the migration is only recorded in golang-migrate's table, which Up takes over
+	NeedsAdoption bool
+}
+
+// Status only reads. It takes no lock and creates nothing, so it can be run against a database another
+// instance is migrating, and a golang-migrate table is reported as it is rather than adopted.
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	legacyVersion, legacy, err := readLegacy(ctx, m.db)
+	if err != nil {
+		return nil, err
+	}
+
+	applied := make(map[int64]Applied)
+
+	if !legacy {
+		var exists bool
+
+		err = m.db.QueryRowContext(ctx, `SELECT to_regclass('schema_migrations') IS NOT NULL`).Scan(&exists)
+		if err != nil {
+			return nil, err
+		}
+
+		if exists {
+			applied, err = readApplied(ctx, m.db)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	var statuses []Status
+
+	for _, migration := range m.migrations {
+		row, found := applied[migration.Version]
+		status := Status{
+			Migration: migration,
+			Applied:   found,
+			AppliedAt: row.AppliedAt,
+			Modified:  found && row.Checksum != migration.Checksum,
+		}
+
+		if legacy && migration.Version <= legacyVersion {
+			status.Applied = true
+			status.NeedsAdoption = true
+		}
+
+		statuses = append(statuses, status)
+	}
+
+	return statuses, nil
+}
+
+func (m *Migrator) migrate(ctx context.Context, target func(applied map[int64]Applied) int64) ([]Step, error) {
+	var done []Step
+
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		applied, err := readApplied(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		steps, err := plan(m.migrations, applied, target(applied))
+		if err != nil {
+			return err
+		}
+
+		for _, step := range steps {
+			err := run(ctx, conn, step)
+			if err != nil {
+				return fmt.Errorf("migrate: %d_%s: %w", step.Version, step.Name, err)
+			}
+			done = append(done, step)
+		}
+
+		return nil
+	})
+
+	return done, err
+}
+
+// withLock holds the advisory lock on one connection for the whole of fn, as the lock belongs to the session.
+// Another instance migrating at the same time waits here, then finds nothing left to do.
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, lockID)
+	if err != nil {
+		return err
+	}
+	defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, lockID)
+
+	err = m.adoptLegacy(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	_, err = conn.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version bigint PRIMARY KEY,
+			name text NOT NULL,
+			checksum text NOT NULL,
+			applied_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+		)`)
+	if err != nil {
+		return err
+	}
+
+	return fn(conn)
+}
+
+// adoptLegacy takes over the schema_migrations table golang-migrate leaves behind, which only has version and
+// dirty columns. It is renamed out of the way and everything up to its version is recorded as applied.
+func (m *Migrator) adoptLegacy(ctx context.Context, conn *sql.Conn) error {
+	version, legacy, err := readLegacy(ctx, conn)
+	if err != nil || !legacy {
+		return err
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `ALTER TABLE schema_migrations RENAME TO schema_migrations_legacy`)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		CREATE TABLE schema_migrations (
+			version bigint PRIMARY KEY,
+			name text NOT NULL,
+			checksum text NOT NULL,
+			applied_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+		)`)
+	if err != nil {
+		return err
+	}
+
+	for _, migration := range m.migrations {
+		if migration.Version > version {
+			break
+		}
+
+		_, err = tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, name, checksum) VALUES ($1, $2, $3)`,
+			migration.Version, migration.Name, migration.Checksum)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// querier is what reading the migration state needs, both the pool and the connection holding the lock have it
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// readLegacy finds the version recorded in a golang-migrate schema_migrations table, legacy is false when the
+// table is ours or missing. A dirty table is an error, a failed golang-migrate run has to be fixed by hand.
+func readLegacy(ctx context.Context, q querier) (version int64, legacy bool, err error) {
+	err = q.QueryRowContext(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM information_schema.columns
+			WHERE table_schema = current_schema() AND table_name = 'schema_migrations' AND column_name = 'dirty'
+		)`).Scan(&legacy)
+	if err != nil || !legacy {
+		return 0, false, err
+	}
+
+	var dirty bool
+
+	err = q.QueryRowContext(ctx, `SELECT version, dirty FROM schema_migrations LIMIT 1`).Scan(&version, &dirty)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return 0, false, err
+	}
+
+	if dirty {
+		return 0, false, fmt.Errorf("migrate: the old schema_migrations table is dirty at version %d, fix it by hand first", version)
+	}
+
+	return version, true, nil
+}
+
+func readApplied(ctx context.Context, q querier) (map[int64]Applied, error) {
+	rows, err := q.QueryContext(ctx, `SELECT version, name, checksum, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int64]Applied)
+
+	for rows.Next() {
+		var a Applied
+		err := rows.Scan(&a.Version, &a.Name, &a.Checksum, &a.AppliedAt)
+		if err != nil {
+			return nil, err
+		}
+		applied[a.Version] = a
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return applied, nil
+}
+
+// run applies one step in its own transaction, postgres DDL is transactional so a failure leaves nothing behind
+func run(ctx context.Context, conn *sql.Conn, step Step) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if step.Up {
+		_, err = tx.ExecContext(ctx, step.UpSQL)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, name, checksum) VALUES ($1, $2, $3)`,
+			step.Version, step.Name, step.Checksum)
+	} else {
+		_, err = tx.ExecContext(ctx, step.DownSQL)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `DELETE FROM schema_migrations WHERE version = $1`, step.Version)
+	}
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
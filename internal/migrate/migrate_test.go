@@ -0,0 +1,167 @@
+package migrate
+
+import (
+	"errors"
+	"movie_api/migrations"
+	"strconv"
+	"testing"
+	"testing/fstest"
+)
+
+func testFiles() fstest.MapFS {
+	return fstest.MapFS{
+		"1_create_movies.up.sql":    {Data: []byte("CREATE TABLE movies ();")},
+		"1_create_movies.down.sql":  {Data: []byte("DROP TABLE movies;")},
+		"2_create_users.up.sql":     {Data: []byte("CREATE TABLE users ();")},
+		"2_create_users.down.sql":   {Data: []byte("DROP TABLE users;")},
+		"10_add_index.up.sql":       {Data: []byte("CREATE INDEX movies_idx ON movies (id);")},
+		"migrations.go":             {Data: []byte("package migrations")},
+		"README.md":                 {Data: []byte("not a migration")},
+		"3_not_really.sql.template": {Data: []byte("ignored")},
+	}
+}
+
+func TestLoad(t *testing.T) {
+	loaded, err := Load(testFiles())
+	if err != nil {
+		t.Fatalf("Failed to load migrations: %v", err)
+	}
+
+	versions := []int64{}
+	for _, m := range loaded {
+		versions = append(versions, m.Version)
+	}
+
+	if len(versions) != 3 || versions[0] != 1 || versions[1] != 2 || versions[2] != 10 {
+		t.Fatalf("Unexpected versions. Expected: [1 2 10], Got: %v", versions)
+	}
+
+	if loaded[0].Name != "create_movies" || loaded[0].DownSQL != "DROP TABLE movies;" {
+		t.Errorf("Unexpected migration. Got: %+v", loaded[0])
+	}
+
+	if loaded[2].DownSQL != "" || loaded[2].Checksum == "" {
+		t.Errorf("Expected an up only migration with a checksum. Got: %+v", loaded[2])
+	}
+}
+
+func TestLoad_Invalid(t *testing.T) {
+	tests := []struct {
+		Name  string
+		files fstest.MapFS
+	}{
+		{"Down without up", fstest.MapFS{"1_create_movies.down.sql": {Data: []byte("DROP TABLE movies;")}}},
+		{"Reused version", fstest.MapFS{
+			"1_create_movies.up.sql": {Data: []byte("CREATE TABLE movies ();")},
+			"1_create_users.up.sql":  {Data: []byte("CREATE TABLE users ();")},
+		}},
+		{"Zero version", fstest.MapFS{"0_create_movies.up.sql": {Data: []byte("CREATE TABLE movies ();")}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			if _, err := Load(tt.files); err == nil {
+				t.Error("Expected an error")
+			}
+		})
+	}
+}
+
+// the embedded directory is what ships, so every file in it has to load
+func TestLoad_Embedded(t *testing.T) {
+	loaded, err := Load(migrations.Files)
+	if err != nil {
+		t.Fatalf("Failed to load embedded migrations: %v", err)
+	}
+
+	for _, m := range loaded {
+		if m.DownSQL == "" {
+			t.Errorf("Expected %d_%s to have a down file", m.Version, m.Name)
+		}
+	}
+}
+
+func TestPlan(t *testing.T) {
+	loaded, err := Load(testFiles())
+	if err != nil {
+		t.Fatalf("Failed to load migrations: %v", err)
+	}
+
+	applied := func(versions ...int64) map[int64]Applied {
+		rows := make(map[int64]Applied)
+		for _, m := range loaded {
+			for _, version := range versions {
+				if m.Version == version {
+					rows[version] = Applied{Version: version, Name: m.Name, Checksum: m.Checksum}
+				}
+			}
+		}
+		return rows
+	}
+
+	tests := []struct {
+		Name     string
+		applied  map[int64]Applied
+		target   int64
+		expected []string
+	}{
+		{"Up from nothing", applied(), 1 << 62, []string{"up 1", "up 2", "up 10"}},
+		{"Up with nothing pending", applied(1, 2, 10), 1 << 62, []string{}},
+		{"Up fills a gap", applied(1, 10), 1 << 62, []string{"up 2"}},
+		{"Goto an earlier version", applied(1, 2), 1, []string{"down 2"}},
+		{"Goto zero", applied(1, 2), 0, []string{"down 2", "down 1"}},
+		{"Goto a later version", applied(1), 2, []string{"up 2"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			steps, err := plan(loaded, tt.applied, tt.target)
+			if err != nil {
+				t.Fatalf("Failed to plan: %v", err)
+			}
+
+			got := []string{}
+			for _, step := range steps {
+				direction := "down"
+				if step.Up {
+					direction = "up"
+				}
+				got = append(got, direction+" "+strconv.FormatInt(step.Version, 10))
+			}
+
+			if len(got) != len(tt.expected) {
+				t.Fatalf("Unexpected steps. Expected: %v, Got: %v", tt.expected, got)
+			}
+			for i := range got {
+				if got[i] != tt.expected[i] {
+					t.Errorf("Unexpected steps. Expected: %v, Got: %v", tt.expected, got)
+				}
+			}
+		})
+	}
+
+	if _, err := plan(loaded, applied(1, 2, 10), 2); !errors.Is(err, ErrNoDownFile) {
+		t.Errorf("Unexpected error reverting an up only migration. Expected: %v, Got: %v", ErrNoDownFile, err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	loaded, err := Load(testFiles())
+	if err != nil {
+		t.Fatalf("Failed to load migrations: %v", err)
+	}
+
+	edited := map[int64]Applied{1: {Version: 1, Name: "create_movies", Checksum: "not the checksum"}}
+
+	var checksumErr *ChecksumError
+	if err := verify(loaded, edited); !errors.As(err, &checksumErr) || checksumErr.Version != 1 {
+		t.Errorf("Unexpected error for an edited migration. Expected a ChecksumError, Got: %v", err)
+	}
+
+	unknown := map[int64]Applied{99: {Version: 99, Name: "from_the_future"}}
+
+	var missingErr *MissingError
+	if err := verify(loaded, unknown); !errors.As(err, &missingErr) || missingErr.Version != 99 {
+		t.Errorf("Unexpected error for an unknown migration. Expected a MissingError, Got: %v", err)
+	}
+}
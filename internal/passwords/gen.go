@@ -0,0 +1,97 @@
+//go:build ignore
+
+// gen builds breached.txt.gz from a plain list of passwords, one per line, most common first. By default the list
+// is the NCSC's 100,000 most used passwords from the Have I Been Pwned corpus, as published in SecLists:
+//
+//	go generate ./internal/passwords
+//
+// Another list can be read from stdin instead, and -top keeps only its first n entries:
+//
+//	go run gen.go -url - -top 20000 < common-passwords.txt
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto/sha1"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+)
+
+// source is where the bundled list comes from, the NCSC published it in 2019 from the passwords seen most often
+// in breaches indexed by Have I Been Pwned
+const source = "https://raw.githubusercontent.com/danielmiessler/SecLists/master/Passwords/Common-Credentials/100k-most-used-passwords-NCSC.txt"
+
+// minEntries guards against a truncated download quietly replacing the list with a much weaker one
+const minEntries = 10000
+
+func main() {
+	url := flag.String("url", source, "where to fetch the password list from, - reads it from stdin")
+	top := flag.Int("top", 0, "only keep the first n passwords, 0 keeps them all")
+	flag.Parse()
+
+	var in io.Reader = os.Stdin
+
+	if *url != "-" {
+		resp, err := http.Get(*url)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			log.Fatalf("fetching %s: %s", *url, resp.Status)
+		}
+
+		in = resp.Body
+	}
+
+	hashes := make(map[string]bool)
+	read := 0
+
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() && (*top == 0 || read < *top) {
+		password := strings.TrimSpace(scanner.Text())
+		if password == "" {
+			continue
+		}
+		read++
+		hashes[fmt.Sprintf("%X", sha1.Sum([]byte(password)))] = true
+	}
+	if err := scanner.Err(); err != nil {
+		log.Fatal(err)
+	}
+
+	if len(hashes) < minEntries && *url == source {
+		log.Fatalf("only %d passwords were read from %s, expected at least %d", len(hashes), *url, minEntries)
+	}
+
+	lines := make([]string, 0, len(hashes))
+	for hash := range hashes {
+		lines = append(lines, hash[:5]+":"+hash[5:])
+	}
+	sort.Strings(lines)
+
+	f, err := os.Create("breached.txt.gz")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+
+	zw := gzip.NewWriter(f)
+	for _, line := range lines {
+		fmt.Fprintln(zw, line)
+	}
+
+	if err := zw.Close(); err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("wrote %d hashes to breached.txt.gz", len(lines))
+}
@@ -0,0 +1,113 @@
+package passwords
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha1"
+	_ "embed"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// breached.txt.gz holds SHA-1 hashes of common and breached passwords in the same prefix:suffix shape as
+// the Have I Been Pwned range API, so a lookup only ever compares against the handful sharing a prefix.
+// It is built by gen.go, which documents where the list comes from.
+//
+//go:generate go run gen.go
+//go:embed breached.txt.gz
+var breachedList []byte
+
+var (
+	loadOnce sync.Once
+	ranges   map[string][]string
+)
+
+func load() {
+	ranges = make(map[string][]string)
+
+	zr, err := gzip.NewReader(bytes.NewReader(breachedList))
+	if err != nil {
+		panic("passwords: corrupt breached list: " + err.Error())
+	}
+	defer zr.Close()
+
+	scanner := bufio.NewScanner(zr)
+	for scanner.Scan() {
+		prefix, suffix, found := strings.Cut(scanner.Text(), ":")
+		if found {
+			ranges[prefix] = append(ranges[prefix], suffix)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		panic("passwords: corrupt breached list: " + err.Error())
+	}
+}
+
+// Range returns the hash suffixes known for a five character SHA-1 prefix
+func Range(prefix string) []string {
+	loadOnce.Do(load)
+	return ranges[strings.ToUpper(prefix)]
+}
+
+// Breached reports whether the password appears in the bundled list of common and breached passwords
+func Breached(plaintext string) bool {
+	hash := fmt.Sprintf("%X", sha1.Sum([]byte(plaintext)))
+
+	for _, suffix := range Range(hash[:5]) {
+		if suffix == hash[5:] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Entropy estimates the strength of a password in bits from the character classes it uses. Repeating
+// the previous character adds nothing, so "aaaaaaaa" scores no better than "a".
+func Entropy(plaintext string) float64 {
+	var lower, upper, digit, symbol, other bool
+
+	length := 0
+	var previous rune
+
+	for i, r := range plaintext {
+		switch {
+		case r <= unicode.MaxASCII && unicode.IsLower(r):
+			lower = true
+		case r <= unicode.MaxASCII && unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		case r <= unicode.MaxASCII:
+			symbol = true
+		default:
+			other = true
+		}
+
+		if i == 0 || r != previous {
+			length++
+		}
+		previous = r
+	}
+
+	pool := 0
+	for _, class := range []struct {
+		used bool
+		size int
+	}{{lower, 26}, {upper, 26}, {digit, 10}, {symbol, 33}, {other, 100}} {
+		if class.used {
+			pool += class.size
+		}
+	}
+
+	if pool == 0 {
+		return 0
+	}
+
+	return float64(length) * math.Log2(float64(pool))
+}
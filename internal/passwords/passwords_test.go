@@ -0,0 +1,55 @@
+package passwords
+
+import "testing"
+
+func TestBreached(t *testing.T) {
+	tests := []struct {
+		Name     string
+		password string
+		breached bool
+	}{
+		{"Most common password", "password", true},
+		{"Common with digits", "password123", true},
+		{"Keyboard walk", "1qaz2wsx", true},
+		{"Case matters", "PASSWORD", false},
+		{"Uncommon passphrase", "correct horse battery staple 42", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			if got := Breached(tt.password); got != tt.breached {
+				t.Errorf("Expected Breached(%q) to be %t, got %t", tt.password, tt.breached, got)
+			}
+		})
+	}
+}
+
+func TestRange(t *testing.T) {
+	// sha1("password") is 5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8
+	suffixes := Range("5baa6")
+
+	found := false
+	for _, suffix := range suffixes {
+		if suffix == "1E4C9B93F3F0682250B6CF8331B7EE68FD8" {
+			found = true
+		}
+	}
+
+	if !found {
+		t.Errorf("Expected the range for 5BAA6 to contain the hash of password, got %v", suffixes)
+	}
+}
+
+func TestEntropy(t *testing.T) {
+	if got := Entropy(""); got != 0 {
+		t.Errorf("Expected an empty password to have no entropy, got %f", got)
+	}
+
+	if Entropy("aaaaaaaaaaaa") >= Entropy("ab") {
+		t.Error("Expected repeated characters to add no entropy")
+	}
+
+	if Entropy("abcdefgh") >= Entropy("abcdEFG1") {
+		t.Error("Expected mixing character classes to raise entropy")
+	}
+}
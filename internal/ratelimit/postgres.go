@@ -0,0 +1,77 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Postgres keeps buckets in the rate_limit_buckets table. Each check is a single upsert, the row lock it takes
+// means two instances can never both spend the last token. The database clock is used throughout so instances
+// with drifting clocks still agree.
+type Postgres struct {
+	DB *sql.DB
+}
+
+// NewPostgres starts a janitor that clears out full buckets every minute. onError is told when that fails,
+// a failed sweep only leaves rows for the next one.
+func NewPostgres(db *sql.DB, onError func(error)) *Postgres {
+	p := &Postgres{DB: db}
+
+	go func() {
+		for {
+			time.Sleep(time.Minute)
+
+			if err := p.purge(context.Background()); err != nil && onError != nil {
+				onError(err)
+			}
+		}
+	}()
+
+	return p
+}
+
+// purge deletes buckets that have refilled, a full bucket is the same as no bucket
+func (s *Postgres) purge(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	_, err := s.DB.ExecContext(ctx, `DELETE FROM rate_limit_buckets WHERE full_at < NOW()`)
+	return err
+}
+
+// refilled is the bucket's tokens after topping it up for the time since it was last seen, with $2 the burst and
+// $3 the rate. It's written out where it's needed since ON CONFLICT DO UPDATE can't name an expression.
+const refilled = `LEAST($2::double precision,
+	b.tokens + EXTRACT(EPOCH FROM NOW() - b.updated_at)::double precision * $3::double precision)`
+
+func (s *Postgres) Allow(ctx context.Context, key string, p Policy) (Result, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	// a new bucket starts full and the request takes a token from it. An existing one is refilled, and a token
+	// spent if there's a whole one, in the same statement. The returned row can't show the tokens from before
+	// the update, so whether this request was let through is kept in allowed.
+	query := `
+		INSERT INTO rate_limit_buckets AS b (key, tokens, allowed, updated_at, full_at)
+		VALUES ($1, $2::double precision - 1, true, NOW(), NOW() + make_interval(secs => 1 / $3::double precision))
+		ON CONFLICT (key) DO UPDATE SET
+			tokens = ` + refilled + ` - (` + refilled + ` >= 1)::int,
+			allowed = ` + refilled + ` >= 1,
+			updated_at = NOW(),
+			full_at = NOW() + make_interval(secs =>
+				($2::double precision - ` + refilled + ` + (` + refilled + ` >= 1)::int) / $3::double precision)
+		RETURNING tokens, allowed`
+
+	var (
+		tokens  float64
+		allowed bool
+	)
+
+	err := s.DB.QueryRowContext(ctx, query, key, float64(p.Burst), p.Rate).Scan(&tokens, &allowed)
+	if err != nil {
+		return Result{}, err
+	}
+
+	return newResult(p, tokens, allowed), nil
+}
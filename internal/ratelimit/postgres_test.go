@@ -0,0 +1,91 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	_ "github.com/jackc/pgx/v4/stdlib"
+	"os"
+	"testing"
+	"time"
+)
+
+// TestPostgres needs a migrated database named by MOVIE_API_TEST_DSN, like the data package's TestPostgresModels.
+// The rate_limit_buckets table is truncated first.
+func TestPostgres(t *testing.T) {
+	dsn := os.Getenv("MOVIE_API_TEST_DSN")
+	if dsn == "" {
+		t.Skip("MOVIE_API_TEST_DSN is not set")
+	}
+
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	if err := db.Ping(); err != nil {
+		t.Skipf("Postgres is unavailable: %v", err)
+	}
+
+	if _, err := db.Exec(`TRUNCATE rate_limit_buckets`); err != nil {
+		t.Fatalf("Failed to truncate rate_limit_buckets: %v", err)
+	}
+
+	// built directly so the test doesn't leave a janitor running
+	p := &Postgres{DB: db}
+	policy := Policy{Name: "test", Rate: 4, Burst: 2}
+
+	first := allow(t, p, "client", policy)
+	if !first.Allowed || first.Limit != 2 || first.Remaining != 1 {
+		t.Errorf("Unexpected first result: %+v", first)
+	}
+
+	if second := allow(t, p, "client", policy); !second.Allowed || second.Remaining != 0 {
+		t.Errorf("Unexpected second result: %+v", second)
+	}
+
+	third := allow(t, p, "client", policy)
+	if third.Allowed || third.RetryAfter <= 0 || third.RetryAfter > time.Second {
+		t.Errorf("Expected the third request to be limited with a retry after of up to a second, got: %+v", third)
+	}
+
+	if other := allow(t, p, "other", policy); !other.Allowed {
+		t.Error("Expected other keys to be unaffected")
+	}
+
+	// at four a second the bucket has a token again well within half a second
+	time.Sleep(500 * time.Millisecond)
+
+	if refilled := allow(t, p, "client", policy); !refilled.Allowed {
+		t.Errorf("Expected the bucket to have refilled, got: %+v", refilled)
+	}
+
+	_, err = db.Exec(`UPDATE rate_limit_buckets SET full_at = NOW() - INTERVAL '1 minute' WHERE key = 'other'`)
+	if err != nil {
+		t.Fatalf("Failed to age bucket: %v", err)
+	}
+
+	if err := p.purge(context.Background()); err != nil {
+		t.Fatalf("Failed to purge buckets: %v", err)
+	}
+
+	var keys []string
+
+	rows, err := db.Query(`SELECT key FROM rate_limit_buckets ORDER BY key`)
+	if err != nil {
+		t.Fatalf("Failed to list buckets: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			t.Fatalf("Failed to scan bucket: %v", err)
+		}
+		keys = append(keys, key)
+	}
+
+	if len(keys) != 1 || keys[0] != "client" {
+		t.Errorf("Expected only the full bucket to be purged, got: %v", keys)
+	}
+}
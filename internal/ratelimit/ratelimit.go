@@ -0,0 +1,132 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// Policy is a token bucket, Burst requests can be made at once and the bucket refills at Rate per second
+type Policy struct {
+	Name  string
+	Rate  float64
+	Burst int
+}
+
+// Scale returns the policy with its rate and burst multiplied, used to give some clients a bigger quota
+func (p Policy) Scale(multiplier float64) Policy {
+	p.Rate *= multiplier
+	p.Burst = int(math.Max(1, math.Round(float64(p.Burst)*multiplier)))
+	return p
+}
+
+// Result carries everything needed for the RateLimit-* and Retry-After headers
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset is how long until the bucket is full again
+	Reset time.Duration
+	// RetryAfter is how long until the next request would be allowed, zero when this one was
+	RetryAfter time.Duration
+}
+
+// Store holds the buckets. Memory keeps them per instance, Postgres shares them between every instance
+// pointed at the same database so limits hold behind a load balancer.
+type Store interface {
+	Allow(ctx context.Context, key string, p Policy) (Result, error)
+}
+
+// newResult builds the result from the tokens left in the bucket once the request has been counted
+func newResult(p Policy, tokens float64, allowed bool) Result {
+	result := Result{
+		Allowed:   allowed,
+		Limit:     p.Burst,
+		Remaining: int(tokens),
+		Reset:     seconds((float64(p.Burst) - tokens) / p.Rate),
+	}
+
+	if !allowed {
+		result.RetryAfter = seconds((1 - tokens) / p.Rate)
+	}
+
+	return result
+}
+
+type bucket struct {
+	tokens   float64
+	lastSeen time.Time
+	// fullAt is when the bucket will have refilled, after which forgetting it changes nothing
+	fullAt time.Time
+}
+
+// take refills the bucket for the time passed since it was last seen then tries to spend a token from it
+func (b *bucket) take(p Policy, now time.Time) Result {
+	elapsed := now.Sub(b.lastSeen).Seconds()
+	b.tokens = math.Min(float64(p.Burst), b.tokens+elapsed*p.Rate)
+	b.lastSeen = now
+
+	allowed := b.tokens >= 1
+	if allowed {
+		b.tokens--
+	}
+
+	result := newResult(p, b.tokens, allowed)
+	b.fullAt = now.Add(result.Reset)
+
+	return result
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// Memory keeps buckets in process, so every instance of the api enforces its own limits
+type Memory struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+func NewMemory() *Memory {
+	m := &Memory{buckets: make(map[string]*bucket)}
+
+	go func() {
+		for {
+			time.Sleep(time.Minute)
+
+			m.mu.Lock()
+			for key, b := range m.buckets {
+				if time.Now().After(b.fullAt) {
+					delete(m.buckets, key)
+				}
+			}
+			m.mu.Unlock()
+		}
+	}()
+
+	return m
+}
+
+func (m *Memory) Allow(ctx context.Context, key string, p Policy) (Result, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+
+	b, found := m.buckets[key]
+	if !found {
+		b = &bucket{tokens: float64(p.Burst), lastSeen: now}
+		m.buckets[key] = b
+	}
+
+	return b.take(p, now), nil
+}
+
+// Flush forgets every bucket, handing all clients a full quota again
+func (m *Memory) Flush() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.buckets = make(map[string]*bucket)
+}
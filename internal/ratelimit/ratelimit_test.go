@@ -0,0 +1,81 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemory_Allow(t *testing.T) {
+	m := NewMemory()
+	policy := Policy{Name: "test", Rate: 1, Burst: 2}
+
+	first := allow(t, m, "client", policy)
+	if !first.Allowed || first.Limit != 2 || first.Remaining != 1 {
+		t.Errorf("Unexpected first result: %+v", first)
+	}
+
+	second := allow(t, m, "client", policy)
+	if !second.Allowed || second.Remaining != 0 {
+		t.Errorf("Unexpected second result: %+v", second)
+	}
+
+	third := allow(t, m, "client", policy)
+	if third.Allowed {
+		t.Error("Expected the third request to be limited")
+	}
+
+	if third.RetryAfter <= 0 || third.RetryAfter > time.Second {
+		t.Errorf("Expected a retry after of up to a second, got %s", third.RetryAfter)
+	}
+
+	if other := allow(t, m, "other", policy); !other.Allowed {
+		t.Error("Expected other keys to be unaffected")
+	}
+}
+
+func TestBucket_Refills(t *testing.T) {
+	policy := Policy{Rate: 2, Burst: 4}
+	now := time.Now()
+
+	b := &bucket{tokens: 0, lastSeen: now}
+
+	if b.take(policy, now).Allowed {
+		t.Error("Expected an empty bucket to refuse")
+	}
+
+	result := b.take(policy, now.Add(time.Second))
+	if !result.Allowed || result.Remaining != 1 {
+		t.Errorf("Expected a second to refill two tokens, got: %+v", result)
+	}
+
+	result = b.take(policy, now.Add(time.Hour))
+	if result.Remaining != 3 {
+		t.Errorf("Expected the bucket to be capped at its burst, got: %+v", result)
+	}
+}
+
+func TestPolicy_Scale(t *testing.T) {
+	scaled := Policy{Rate: 2, Burst: 4}.Scale(2.5)
+
+	if scaled.Rate != 5 || scaled.Burst != 10 {
+		t.Errorf("Unexpected scaled policy: %+v", scaled)
+	}
+
+	if tiny := (Policy{Rate: 1, Burst: 1}).Scale(0.1); tiny.Burst != 1 {
+		t.Errorf("Expected burst to never drop below one, got %d", tiny.Burst)
+	}
+}
+
+func allow(t *testing.T, s Store, key string, p Policy) Result {
+	result, err := s.Allow(context.Background(), key, p)
+	if err != nil {
+		t.Fatalf("Failed to check the limit: %v", err)
+	}
+	return result
+}
+
+func TestStoresImplementStore(t *testing.T) {
+	var _ Store = (*Memory)(nil)
+	var _ Store = (*Postgres)(nil)
+}
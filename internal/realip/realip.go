@@ -0,0 +1,123 @@
+package realip
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// Resolver works out the client's address for requests that may have come through our own proxies. Forwarding
+// headers are read right to left, each hop was appended by the one before it, so the first address that isn't
+// a trusted proxy is the client. Anything further left was written by the client and can't be believed.
+type Resolver struct {
+	trusted []netip.Prefix
+}
+
+// New takes the trusted proxies as CIDRs, a bare address is treated as a single host
+func New(proxies []string) (*Resolver, error) {
+	r := &Resolver{}
+
+	for _, proxy := range proxies {
+		if !strings.Contains(proxy, "/") {
+			addr, err := netip.ParseAddr(proxy)
+			if err != nil {
+				return nil, fmt.Errorf("realip: invalid trusted proxy %q: %w", proxy, err)
+			}
+			r.trusted = append(r.trusted, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("realip: invalid trusted proxy %q: %w", proxy, err)
+		}
+		r.trusted = append(r.trusted, prefix.Masked())
+	}
+
+	return r, nil
+}
+
+func (r *Resolver) isTrusted(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range r.trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the client address for the request, Forwarded is preferred over X-Forwarded-For when both are sent
+func (r *Resolver) ClientIP(req *http.Request) (string, error) {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return "", err
+	}
+
+	remote, err := netip.ParseAddr(host)
+	if err != nil || !r.isTrusted(remote) {
+		return host, nil
+	}
+
+	var hops []string
+	if forwarded := req.Header.Values("Forwarded"); len(forwarded) > 0 {
+		hops = parseForwarded(forwarded)
+	} else {
+		hops = parseXForwardedFor(req.Header.Values("X-Forwarded-For"))
+	}
+
+	client := remote
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, err := parseNode(hops[i])
+		if err != nil {
+			// obfuscated or garbled hops can't be followed any further
+			break
+		}
+
+		client = addr
+		if !r.isTrusted(addr) {
+			break
+		}
+	}
+
+	return client.Unmap().String(), nil
+}
+
+func parseXForwardedFor(values []string) []string {
+	var hops []string
+	for _, value := range values {
+		for _, hop := range strings.Split(value, ",") {
+			hops = append(hops, strings.TrimSpace(hop))
+		}
+	}
+	return hops
+}
+
+// parseForwarded pulls the for= parameter from each element of RFC 7239 Forwarded headers
+func parseForwarded(values []string) []string {
+	var hops []string
+	for _, value := range values {
+		for _, element := range strings.Split(value, ",") {
+			node := ""
+			for _, pair := range strings.Split(element, ";") {
+				key, val, found := strings.Cut(strings.TrimSpace(pair), "=")
+				if found && strings.EqualFold(key, "for") {
+					node = strings.Trim(val, `"`)
+				}
+			}
+			hops = append(hops, node)
+		}
+	}
+	return hops
+}
+
+// parseNode accepts a bare address or one with a port, IPv6 addresses may be bracketed
+func parseNode(node string) (netip.Addr, error) {
+	if addrPort, err := netip.ParseAddrPort(node); err == nil {
+		return addrPort.Addr(), nil
+	}
+
+	return netip.ParseAddr(strings.TrimSuffix(strings.TrimPrefix(node, "["), "]"))
+}
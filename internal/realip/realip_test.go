@@ -0,0 +1,60 @@
+package realip
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestResolver_ClientIP(t *testing.T) {
+	resolver, err := New([]string{"10.0.0.0/8", "192.168.1.1"})
+	if err != nil {
+		t.Fatalf("Failed to create resolver: %v", err)
+	}
+
+	tests := []struct {
+		Name       string
+		remoteAddr string
+		headers    map[string]string
+		expected   string
+	}{
+		{"Direct client", "203.0.113.7:5000", nil, "203.0.113.7"},
+		{"Untrusted peer can't spoof", "203.0.113.7:5000", map[string]string{"X-Forwarded-For": "1.1.1.1"}, "203.0.113.7"},
+		{"Single trusted proxy", "10.0.0.5:80", map[string]string{"X-Forwarded-For": "198.51.100.4"}, "198.51.100.4"},
+		{"Spoofed entries left of the client are ignored", "10.0.0.5:80", map[string]string{"X-Forwarded-For": "1.1.1.1, 198.51.100.4, 10.0.0.9"}, "198.51.100.4"},
+		{"Only trusted hops", "10.0.0.5:80", map[string]string{"X-Forwarded-For": "10.0.0.9"}, "10.0.0.9"},
+		{"Trusted single host", "192.168.1.1:80", map[string]string{"X-Forwarded-For": "198.51.100.4"}, "198.51.100.4"},
+		{"Forwarded header", "10.0.0.5:80", map[string]string{"Forwarded": `for=192.0.2.60;proto=http, for="10.1.2.3"`}, "192.0.2.60"},
+		{"Forwarded IPv6 with port", "10.0.0.5:80", map[string]string{"Forwarded": `for="[2001:db8:cafe::17]:4711"`}, "2001:db8:cafe::17"},
+		{"Forwarded preferred over X-Forwarded-For", "10.0.0.5:80", map[string]string{"Forwarded": "for=192.0.2.60", "X-Forwarded-For": "198.51.100.4"}, "192.0.2.60"},
+		{"Obfuscated hop stops the walk", "10.0.0.5:80", map[string]string{"Forwarded": "for=192.0.2.60, for=_hidden"}, "10.0.0.5"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for key, value := range tt.headers {
+				req.Header.Set(key, value)
+			}
+
+			ip, err := resolver.ClientIP(req)
+			if err != nil {
+				t.Fatalf("Failed to resolve client ip: %v", err)
+			}
+
+			if ip != tt.expected {
+				t.Errorf("Unexpected client ip. Expected: %s, Got: %s", tt.expected, ip)
+			}
+		})
+	}
+}
+
+func TestNew_RejectsBadProxies(t *testing.T) {
+	if _, err := New([]string{"not-an-ip"}); err == nil {
+		t.Error("Expected an error for an invalid proxy")
+	}
+
+	if _, err := New([]string{"10.0.0.0/99"}); err == nil {
+		t.Error("Expected an error for an invalid CIDR")
+	}
+}
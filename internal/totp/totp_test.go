@@ -0,0 +1,86 @@
+package totp
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+// secret from the RFC 6238 appendix B test vectors
+var rfcSecret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+func TestCode(t *testing.T) {
+	tests := []struct {
+		Name     string
+		unix     int64
+		expected string
+	}{
+		{"59 seconds", 59, "287082"},
+		{"1111111109 seconds", 1111111109, "081804"},
+		{"1234567890 seconds", 1234567890, "005924"},
+		{"2000000000 seconds", 2000000000, "279037"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			code, err := Code(rfcSecret, time.Unix(tt.unix, 0))
+			if err != nil {
+				t.Fatalf("Failed to generate code: %v", err)
+			}
+
+			if code != tt.expected {
+				t.Errorf("Unexpected code. Expected: %s, Got: %s", tt.expected, code)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+
+	code, _ := Code(rfcSecret, now)
+
+	step, ok := Validate(rfcSecret, code, now)
+	if !ok {
+		t.Error("Expected the current code to validate")
+	}
+
+	if step != 1234567890/30 {
+		t.Errorf("Unexpected step. Expected: %d, Got: %d", 1234567890/30, step)
+	}
+
+	// the code keeps the step it was generated for, whenever it's checked
+	if previous, ok := Validate(rfcSecret, code, now.Add(30*time.Second)); !ok || previous != step {
+		t.Error("Expected the previous period's code to validate with its own step")
+	}
+
+	if _, ok := Validate(rfcSecret, code, now.Add(2*time.Minute)); ok {
+		t.Error("Expected a stale code to be rejected")
+	}
+
+	if _, ok := Validate(rfcSecret, "12345", now); ok {
+		t.Error("Expected a short code to be rejected")
+	}
+}
+
+func TestGenerateSecretAndURI(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatalf("Failed to generate secret: %v", err)
+	}
+
+	if len(secret) != 32 {
+		t.Errorf("Expected a 32 character secret, got %d", len(secret))
+	}
+
+	uri := URI("MovieBuff", "alice@example.com", secret)
+
+	if !strings.HasPrefix(uri, "otpauth://totp/MovieBuff:alice@example.com?") {
+		t.Errorf("Unexpected URI: %s", uri)
+	}
+
+	if !strings.Contains(uri, "secret="+secret) {
+		t.Errorf("Expected URI to contain the secret, got: %s", uri)
+	}
+}
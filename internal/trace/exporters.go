@@ -0,0 +1,182 @@
+package trace
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// WriterExporter writes one json object per span, to stdout or a file, so traces can be read without a collector
+type WriterExporter struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+func NewWriterExporter(out io.Writer) *WriterExporter {
+	return &WriterExporter{out: out}
+}
+
+type spanRecord struct {
+	TraceID    string            `json:"trace_id"`
+	SpanID     string            `json:"span_id"`
+	ParentID   string            `json:"parent_id,omitempty"`
+	Name       string            `json:"name"`
+	Start      time.Time         `json:"start"`
+	DurationMS float64           `json:"duration_ms"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+	Error      string            `json:"error,omitempty"`
+}
+
+func (e *WriterExporter) Export(ctx context.Context, spans []*Span) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	enc := json.NewEncoder(e.out)
+
+	for _, span := range spans {
+		record := spanRecord{
+			TraceID:    span.Context.TraceID.String(),
+			SpanID:     span.Context.SpanID.String(),
+			Name:       span.Name,
+			Start:      span.Start.UTC(),
+			DurationMS: float64(span.End.Sub(span.Start).Microseconds()) / 1000,
+			Attributes: span.Attributes,
+			Error:      span.Err,
+		}
+
+		if span.Parent != (SpanID{}) {
+			record.ParentID = span.Parent.String()
+		}
+
+		if err := enc.Encode(record); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// OTLPExporter posts spans to an OpenTelemetry collector using OTLP over http with the json encoding
+type OTLPExporter struct {
+	endpoint string
+	service  string
+	client   *http.Client
+}
+
+// NewOTLPExporter sends to endpoint, usually http://collector:4318/v1/traces
+func NewOTLPExporter(endpoint, service string) *OTLPExporter {
+	return &OTLPExporter{
+		endpoint: endpoint,
+		service:  service,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpStatus struct {
+	// 1 is ok, 2 is error
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+// otlpKinds maps our kinds onto the SpanKind enum in the OTLP protos
+var otlpKinds = map[Kind]int{
+	KindInternal: 1,
+	KindServer:   2,
+	KindClient:   3,
+}
+
+func (e *OTLPExporter) Export(ctx context.Context, spans []*Span) error {
+	converted := make([]otlpSpan, 0, len(spans))
+
+	for _, span := range spans {
+		s := otlpSpan{
+			TraceID:           span.Context.TraceID.String(),
+			SpanID:            span.Context.SpanID.String(),
+			Name:              span.Name,
+			Kind:              otlpKinds[span.Kind],
+			StartTimeUnixNano: strconv.FormatInt(span.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.End.UnixNano(), 10),
+			Status:            otlpStatus{Code: 1},
+		}
+
+		if span.Parent != (SpanID{}) {
+			s.ParentSpanID = span.Parent.String()
+		}
+
+		for key, value := range span.Attributes {
+			s.Attributes = append(s.Attributes, otlpAttribute{Key: key, Value: otlpValue{StringValue: value}})
+		}
+
+		if span.Err != "" {
+			s.Status = otlpStatus{Code: 2, Message: span.Err}
+		}
+
+		converted = append(converted, s)
+	}
+
+	payload := map[string]any{
+		"resourceSpans": []any{
+			map[string]any{
+				"resource": map[string]any{
+					"attributes": []otlpAttribute{{Key: "service.name", Value: otlpValue{StringValue: e.service}}},
+				},
+				"scopeSpans": []any{
+					map[string]any{
+						"scope": map[string]string{"name": "movie_api/internal/trace"},
+						"spans": converted,
+					},
+				},
+			},
+		},
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("trace: otlp export failed with status %s", res.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,394 @@
+package trace
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+type TraceID [16]byte
+
+type SpanID [8]byte
+
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// SpanContext is the part of a span that crosses process boundaries in the traceparent header
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != TraceID{} && sc.SpanID != SpanID{}
+}
+
+// Traceparent formats the context as a W3C Trace Context traceparent header
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%s-%s-%s", sc.TraceID, sc.SpanID, flags)
+}
+
+// ParseTraceparent reads a W3C traceparent header, ok is false for anything malformed or all zero
+func ParseTraceparent(header string) (sc SpanContext, ok bool) {
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return SpanContext{}, false
+	}
+
+	// version 00 has exactly four fields, later versions may append more
+	if parts[0] == "00" && len(parts) != 4 {
+		return SpanContext{}, false
+	}
+
+	traceID, err := hex.DecodeString(parts[1])
+	if err != nil || len(traceID) != 16 {
+		return SpanContext{}, false
+	}
+
+	spanID, err := hex.DecodeString(parts[2])
+	if err != nil || len(spanID) != 8 {
+		return SpanContext{}, false
+	}
+
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil || len(flags) != 1 {
+		return SpanContext{}, false
+	}
+
+	copy(sc.TraceID[:], traceID)
+	copy(sc.SpanID[:], spanID)
+	sc.Sampled = flags[0]&1 == 1
+
+	return sc, sc.IsValid()
+}
+
+type Kind int
+
+const (
+	KindInternal Kind = iota
+	KindServer
+	KindClient
+)
+
+// Span times one unit of work. A nil span is valid and does nothing, which is what Start hands out when tracing
+// is off or the trace wasn't sampled.
+type Span struct {
+	tracer *Tracer
+
+	mu         sync.Mutex
+	Name       string
+	Kind       Kind
+	Context    SpanContext
+	Parent     SpanID
+	Start      time.Time
+	End        time.Time
+	Attributes map[string]string
+	Err        string
+	ended      bool
+}
+
+func (s *Span) SetName(name string) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.Name = name
+}
+
+func (s *Span) SetAttribute(key, value string) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.Attributes[key] = value
+}
+
+// SetError marks the span as failed, a nil error is ignored so it can be called unconditionally
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.Err = err.Error()
+}
+
+// Finish ends the span and queues it for export. Only the first call counts, so it's safe to defer as a
+// fallback after ending the span early on the happy path.
+func (s *Span) Finish() {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.End = time.Now()
+	s.mu.Unlock()
+
+	s.tracer.enqueue(s)
+}
+
+// SpanContext returns the identifiers to propagate, the zero value for a nil span
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.Context
+}
+
+type spanContextKey struct{}
+
+type remoteContextKey struct{}
+
+// ContextWithSpan makes span the parent of any span started from the returned context
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	return context.WithValue(ctx, spanContextKey{}, span)
+}
+
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanContextKey{}).(*Span)
+	return span
+}
+
+// ContextWithRemote records a parent that lives in another process, read from an incoming traceparent
+func ContextWithRemote(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, remoteContextKey{}, sc)
+}
+
+// Exporter ships finished spans somewhere, it is called from a single goroutine with batches of spans
+type Exporter interface {
+	Export(ctx context.Context, spans []*Span) error
+}
+
+// Tracer starts spans and exports them in batches off the request path
+type Tracer struct {
+	exporter Exporter
+	// ratio of new traces that are recorded, traces started elsewhere follow the caller's decision
+	ratio float64
+	queue chan *Span
+	done  chan struct{}
+	// closing guards queue, spans can still finish after Shutdown and must not send on a closed channel
+	closing sync.RWMutex
+	closed  bool
+	// onError is told about failed exports, an exporter being down mustn't take requests with it
+	onError func(error)
+}
+
+const (
+	queueSize     = 2048
+	batchSize     = 512
+	flushInterval = 5 * time.Second
+)
+
+func New(exporter Exporter, ratio float64, onError func(error)) *Tracer {
+	t := &Tracer{
+		exporter: exporter,
+		ratio:    ratio,
+		queue:    make(chan *Span, queueSize),
+		done:     make(chan struct{}),
+		onError:  onError,
+	}
+
+	go t.run()
+
+	return t
+}
+
+func (t *Tracer) run() {
+	defer close(t.done)
+
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	var batch []*Span
+
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		if err := t.exporter.Export(ctx, batch); err != nil && t.onError != nil {
+			t.onError(err)
+		}
+		batch = nil
+	}
+
+	for {
+		select {
+		case span, ok := <-t.queue:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, span)
+			if len(batch) >= batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// enqueue drops spans rather than blocking when the exporter can't keep up
+func (t *Tracer) enqueue(span *Span) {
+	t.closing.RLock()
+	defer t.closing.RUnlock()
+
+	if t.closed {
+		return
+	}
+
+	select {
+	case t.queue <- span:
+	default:
+	}
+}
+
+// Shutdown exports whatever is still queued, spans finished after it are dropped
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	t.closing.Lock()
+	if !t.closed {
+		t.closed = true
+		close(t.queue)
+	}
+	t.closing.Unlock()
+
+	select {
+	case <-t.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (t *Tracer) Start(ctx context.Context, name string, kind Kind) (context.Context, *Span) {
+	var parent SpanContext
+
+	if span := SpanFromContext(ctx); span != nil {
+		parent = span.Context
+	} else if remote, ok := ctx.Value(remoteContextKey{}).(SpanContext); ok {
+		parent = remote
+	}
+
+	sc := SpanContext{SpanID: newSpanID()}
+
+	if parent.IsValid() {
+		sc.TraceID = parent.TraceID
+		sc.Sampled = parent.Sampled
+	} else {
+		sc.TraceID = newTraceID()
+		sc.Sampled = sample(sc.TraceID, t.ratio)
+	}
+
+	if !sc.Sampled {
+		// keep the ids flowing so downstream services see a consistent, unsampled trace
+		return ContextWithRemote(ctx, sc), nil
+	}
+
+	span := &Span{
+		tracer:     t,
+		Name:       name,
+		Kind:       kind,
+		Context:    sc,
+		Parent:     parent.SpanID,
+		Start:      time.Now(),
+		Attributes: make(map[string]string),
+	}
+
+	return ContextWithSpan(ctx, span), span
+}
+
+// sample decides on the trace id itself, so every service with the same ratio agrees on a trace
+func sample(id TraceID, ratio float64) bool {
+	if ratio >= 1 {
+		return true
+	}
+	if ratio <= 0 {
+		return false
+	}
+
+	return float64(binary.BigEndian.Uint64(id[8:])>>11)/(1<<53) < ratio
+}
+
+func newTraceID() (id TraceID) {
+	_, _ = rand.Read(id[:])
+	return id
+}
+
+func newSpanID() (id SpanID) {
+	_, _ = rand.Read(id[:])
+	return id
+}
+
+var global struct {
+	mu     sync.RWMutex
+	tracer *Tracer
+}
+
+// SetTracer installs the tracer used by Start, nil turns tracing off
+func SetTracer(t *Tracer) {
+	global.mu.Lock()
+	defer global.mu.Unlock()
+
+	global.tracer = t
+}
+
+// Start begins a span on the installed tracer, the same way data.PreferredHasher is picked once in main and
+// used everywhere. With no tracer the context is returned untouched along with a nil span.
+func Start(ctx context.Context, name string) (context.Context, *Span) {
+	return StartKind(ctx, name, KindInternal)
+}
+
+func StartKind(ctx context.Context, name string, kind Kind) (context.Context, *Span) {
+	global.mu.RLock()
+	t := global.tracer
+	global.mu.RUnlock()
+
+	if t == nil {
+		return ctx, nil
+	}
+
+	return t.Start(ctx, name, kind)
+}
+
+// Traceparent returns the header to send with outgoing requests made under ctx, empty if there is no trace
+func Traceparent(ctx context.Context) string {
+	if span := SpanFromContext(ctx); span != nil {
+		return span.Context.Traceparent()
+	}
+	if remote, ok := ctx.Value(remoteContextKey{}).(SpanContext); ok && remote.IsValid() {
+		return remote.Traceparent()
+	}
+	return ""
+}
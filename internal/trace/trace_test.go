@@ -0,0 +1,152 @@
+package trace
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		Name    string
+		header  string
+		ok      bool
+		sampled bool
+	}{
+		{"Sampled", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true, true},
+		{"Not sampled", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", true, false},
+		{"Future version with extra fields", "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", true, true},
+		{"Extra fields on version 00", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", false, false},
+		{"Forbidden version", "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false, false},
+		{"Zero trace id", "00-00000000000000000000000000000000-00f067aa0ba902b7-01", false, false},
+		{"Short span id", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa-01", false, false},
+		{"Garbage", "not a traceparent", false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			sc, ok := ParseTraceparent(tt.header)
+			if ok != tt.ok {
+				t.Fatalf("Unexpected ok. Expected: %t, Got: %t", tt.ok, ok)
+			}
+
+			if ok && sc.Sampled != tt.sampled {
+				t.Errorf("Unexpected sampled flag. Expected: %t, Got: %t", tt.sampled, sc.Sampled)
+			}
+		})
+	}
+
+	sc, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	if got := sc.Traceparent(); got != "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01" {
+		t.Errorf("Unexpected round trip. Got: %s", got)
+	}
+}
+
+func TestTracer_ParentChild(t *testing.T) {
+	var buf bytes.Buffer
+	tracer := New(NewWriterExporter(&buf), 1, nil)
+
+	remote, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ctx := ContextWithRemote(context.Background(), remote)
+
+	ctx, server := tracer.Start(ctx, "GET /v1/movies", KindServer)
+	_, child := tracer.Start(ctx, "MovieModel.GetAll", KindInternal)
+	child.SetError(errors.New("boom"))
+	child.Finish()
+	server.Finish()
+	server.Finish()
+
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Failed to shut down: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 spans, got %d: %s", len(lines), buf.String())
+	}
+
+	var records []spanRecord
+	for _, line := range lines {
+		var record spanRecord
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("Failed to decode span: %v", err)
+		}
+		records = append(records, record)
+	}
+
+	childRecord, serverRecord := records[0], records[1]
+
+	if serverRecord.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || serverRecord.ParentID != "00f067aa0ba902b7" {
+		t.Errorf("Expected the server span to continue the remote trace. Got: %+v", serverRecord)
+	}
+
+	if childRecord.TraceID != serverRecord.TraceID || childRecord.ParentID != serverRecord.SpanID {
+		t.Errorf("Expected the child to hang off the server span. Got: %+v", childRecord)
+	}
+
+	if childRecord.Error != "boom" {
+		t.Errorf("Unexpected error. Expected: boom, Got: %s", childRecord.Error)
+	}
+}
+
+func TestTracer_Unsampled(t *testing.T) {
+	var buf bytes.Buffer
+	tracer := New(NewWriterExporter(&buf), 0, nil)
+
+	ctx, span := tracer.Start(context.Background(), "dropped", KindServer)
+	if span != nil {
+		t.Error("Expected no span for an unsampled trace")
+	}
+
+	// nil spans are safe to use
+	span.SetAttribute("key", "value")
+	span.Finish()
+
+	if header := Traceparent(ctx); !strings.HasSuffix(header, "-00") {
+		t.Errorf("Expected the unsampled trace to still propagate. Got: %q", header)
+	}
+
+	_ = tracer.Shutdown(context.Background())
+
+	if buf.Len() != 0 {
+		t.Errorf("Expected nothing to be exported. Got: %s", buf.String())
+	}
+}
+
+func TestOTLPExporter(t *testing.T) {
+	var payload map[string]any
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		_ = json.Unmarshal(body, &payload)
+	}))
+	defer srv.Close()
+
+	span := &Span{
+		Name:       "GET /v1/movies",
+		Kind:       KindServer,
+		Context:    SpanContext{TraceID: TraceID{1}, SpanID: SpanID{2}, Sampled: true},
+		Start:      time.Unix(0, 1000),
+		End:        time.Unix(0, 2000),
+		Attributes: map[string]string{"http.status_code": "200"},
+	}
+
+	err := NewOTLPExporter(srv.URL, "movie_api").Export(context.Background(), []*Span{span})
+	if err != nil {
+		t.Fatalf("Failed to export: %v", err)
+	}
+
+	out, _ := json.Marshal(payload)
+	for _, expected := range []string{`"service.name"`, `"traceId":"01000000000000000000000000000000"`, `"kind":2`, `"startTimeUnixNano":"1000"`} {
+		if !strings.Contains(string(out), expected) {
+			t.Errorf("Expected payload to contain %s, Got: %s", expected, out)
+		}
+	}
+}
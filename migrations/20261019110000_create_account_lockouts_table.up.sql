@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS account_lockouts (
+    user_id bigint PRIMARY KEY REFERENCES users ON DELETE CASCADE,
+    failed_attempts integer NOT NULL DEFAULT 0,
+    last_failed_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    locked_until timestamp(0) with time zone
+);
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS account_deletions;
+
+DROP INDEX IF EXISTS movies_created_by_idx;
+ALTER TABLE movies DROP COLUMN IF EXISTS created_by;
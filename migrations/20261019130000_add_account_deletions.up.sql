@@ -0,0 +1,9 @@
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS created_by bigint REFERENCES users ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS movies_created_by_idx ON movies (created_by);
+
+CREATE TABLE IF NOT EXISTS account_deletions (
+    user_id bigint PRIMARY KEY REFERENCES users ON DELETE CASCADE,
+    requested_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    delete_after timestamp(0) with time zone NOT NULL
+);
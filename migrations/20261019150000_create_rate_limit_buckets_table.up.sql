@@ -0,0 +1,8 @@
+CREATE UNLOGGED TABLE IF NOT EXISTS rate_limit_buckets (
+    key text PRIMARY KEY,
+    tokens double precision NOT NULL,
+    updated_at timestamp with time zone NOT NULL,
+    full_at timestamp with time zone NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS rate_limit_buckets_full_at_idx ON rate_limit_buckets (full_at);
@@ -0,0 +1 @@
+ALTER TABLE rate_limit_buckets DROP COLUMN IF EXISTS allowed;
@@ -0,0 +1 @@
+ALTER TABLE rate_limit_buckets ADD COLUMN IF NOT EXISTS allowed boolean NOT NULL DEFAULT true;
@@ -0,0 +1,7 @@
+// Package migrations embeds the schema, so the api binary can apply it without an external tool
+package migrations
+
+import "embed"
+
+//go:embed *.sql
+var Files embed.FS